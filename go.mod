@@ -2,12 +2,14 @@ module github.com/magodo/hclgrep
 
 go 1.17
 
-require github.com/hashicorp/hcl/v2 v2.11.1
+require (
+	github.com/hashicorp/hcl/v2 v2.11.1
+	github.com/zclconf/go-cty v1.8.0
+)
 
 require (
 	github.com/agext/levenshtein v1.2.1 // indirect
 	github.com/apparentlymart/go-textseg/v13 v13.0.0 // indirect
 	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
-	github.com/zclconf/go-cty v1.8.0 // indirect
 	golang.org/x/text v0.3.5 // indirect
 )
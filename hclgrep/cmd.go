@@ -3,9 +3,10 @@ package hclgrep
 import (
 	"flag"
 	"fmt"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
-	"strings"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
@@ -20,12 +21,27 @@ const (
 	CmdNameRx                    = "rx"
 	CmdNameParent                = "p"
 	CmdNameWrite                 = "w"
+	CmdNameSubstitute            = "s"
+	CmdNameOr                    = "or"
+	CmdNameAnd                   = "and"
+	CmdNameAs                    = "as"
+	CmdNameUnion                 = "union"
+	CmdNameIntersect             = "intersect"
+	CmdNameSubtract              = "subtract"
+)
+
+// The arguments that start and end a group of commands, e.g. -or ( -x a ) ( -x b )
+const (
+	groupStart = "("
+	groupEnd   = ")"
 )
 
 type Cmd struct {
 	name  CmdName
 	src   string
 	value CmdValue
+	// the groups of commands of -or and -and
+	groups [][]Cmd
 }
 
 type CmdValue interface {
@@ -41,6 +57,8 @@ func (v CmdValueRx) Value() interface{} { return v }
 
 type CmdValueNode struct {
 	hclsyntax.Node
+	// the wildcard table of the pattern
+	wildcards []wildcard
 }
 
 func (v CmdValueNode) Value() interface{} { return v.Node }
@@ -53,14 +71,83 @@ type CmdValueString string
 
 func (v CmdValueString) Value() interface{} { return v }
 
+type CmdValueTemplate struct {
+	template
+}
+
+func (v CmdValueTemplate) Value() interface{} { return v.template }
+
+// cmdParser collects the commands from the command line, where the commands inside a group are added to the group.
+type cmdParser struct {
+	// the command lists being parsed, the last of which is the innermost open group (or the top level commands)
+	stack []*[]Cmd
+}
+
+func newCmdParser(cmds *[]Cmd) *cmdParser {
+	return &cmdParser{stack: []*[]Cmd{cmds}}
+}
+
+func (p *cmdParser) add(cmd Cmd) {
+	cmds := p.stack[len(p.stack)-1]
+	*cmds = append(*cmds, cmd)
+}
+
+// group handles the argument that starts or ends a group.
+func (p *cmdParser) group(arg string) error {
+	if arg == groupEnd {
+		if len(p.stack) == 1 {
+			return fmt.Errorf("unmatched %q", groupEnd)
+		}
+		p.stack = p.stack[:len(p.stack)-1]
+		return nil
+	}
+	cmds := *p.stack[len(p.stack)-1]
+	if len(cmds) == 0 || (cmds[len(cmds)-1].name != CmdNameOr && cmds[len(cmds)-1].name != CmdNameAnd) {
+		return fmt.Errorf("%q must follow `-%s`, `-%s` or another group", groupStart, CmdNameOr, CmdNameAnd)
+	}
+	cmd := &cmds[len(cmds)-1]
+	cmd.groups = append(cmd.groups, nil)
+	p.stack = append(p.stack, &cmd.groups[len(cmd.groups)-1])
+	return nil
+}
+
+// done checks that all the groups are ended.
+func (p *cmdParser) done() error {
+	if len(p.stack) != 1 {
+		return fmt.Errorf("unmatched %q", groupStart)
+	}
+	return nil
+}
+
 type strCmdFlag struct {
-	name CmdName
-	cmds *[]Cmd
+	name   CmdName
+	parser *cmdParser
 }
 
 func (o *strCmdFlag) String() string { return "" }
 func (o *strCmdFlag) Set(val string) error {
-	*o.cmds = append(*o.cmds, Cmd{name: o.name, src: val})
+	o.parser.add(Cmd{name: o.name, src: val})
+	return nil
+}
+
+// groupCmdFlag is the flag of the command that takes the groups of commands following it, instead of a value.
+type groupCmdFlag struct {
+	name   CmdName
+	parser *cmdParser
+}
+
+func (o *groupCmdFlag) String() string   { return "" }
+func (o *groupCmdFlag) IsBoolFlag() bool { return true }
+func (o *groupCmdFlag) Set(val string) error {
+	o.parser.add(Cmd{name: o.name})
+	return nil
+}
+
+type strSliceFlag []string
+
+func (o *strSliceFlag) String() string { return "" }
+func (o *strSliceFlag) Set(val string) error {
+	*o = append(*o, val)
 	return nil
 }
 
@@ -68,79 +155,366 @@ func ParseArgs(args []string) ([]Option, []string, error) {
 	flagSet := flag.NewFlagSet("hclgrep", flag.ContinueOnError)
 	flagSet.Usage = usage
 
+	var unordered bool
+	flagSet.BoolVar(&unordered, "unordered", false, "match the elements of bodies and objects regardless of their order")
+
 	var prefix bool
 	flagSet.BoolVar(&prefix, "H", false, "prefix filename and byte offset for a match")
 
+	var vimgrep bool
+	flagSet.BoolVar(&vimgrep, "vimgrep", false, "print one line per match in the form of \"file:line:column: text\"")
+	flagSet.BoolVar(&vimgrep, "n", false, "shorthand of -vimgrep")
+
+	var before, after, context int
+	flagSet.IntVar(&after, "A", 0, "number of context lines to print after each match")
+	flagSet.IntVar(&before, "B", 0, "number of context lines to print before each match")
+	flagSet.IntVar(&context, "C", 0, "number of context lines to print before and after each match")
+
+	var color string
+	flagSet.StringVar(&color, "color", string(ColorAuto), "when to colorize the output")
+
+	var quiet bool
+	flagSet.BoolVar(&quiet, "q", false, "suppress the output and stop at the first match")
+
+	var inplace bool
+	flagSet.BoolVar(&inplace, "i", false, "write the substituted content back to the files")
+
+	var backup bool
+	flagSet.BoolVar(&backup, "backup", false, "keep a backup of the original files when writing in place")
+
+	var diff bool
+	flagSet.BoolVar(&diff, "d", false, "print the unified diff of the substituted content")
+
+	var includes, excludes strSliceFlag
+	flagSet.Var(&includes, "include", "select files matching the glob when walking directories")
+	flagSet.Var(&excludes, "exclude", "skip files and directories matching the glob when walking directories")
+
+	var noIgnore bool
+	flagSet.BoolVar(&noIgnore, "no-ignore", false, "walk into the VCS, vendor and .terraform directories")
+
+	var jobs int
+	flagSet.IntVar(&jobs, "j", runtime.NumCPU(), "number of files processed concurrently")
+
+	var format string
+	flagSet.StringVar(&format, "format", string(FormatText), "output format of the matches")
+
+	var count, filesWithMatches, filesWithoutMatches bool
+	flagSet.BoolVar(&count, "c", false, "print the number of matches of each file")
+	flagSet.BoolVar(&filesWithMatches, "l", false, "print the names of the files that have any match")
+	flagSet.BoolVar(&filesWithoutMatches, "L", false, "print the names of the files that have no match")
+
+	var jsonOutput bool
+	flagSet.BoolVar(&jsonOutput, "json", false, "output the matches as JSON Lines (shorthand of -format=json)")
+
+	var ruleFiles strSliceFlag
+	flagSet.Var(&ruleFiles, "f", "load the rules from the file")
+
 	var cmds []Cmd
-	flagSet.Var(&strCmdFlag{
-		name: CmdNameMatch,
-		cmds: &cmds,
-	}, string(CmdNameMatch), "")
-	flagSet.Var(&strCmdFlag{
-		name: CmdNameFilterMatch,
-		cmds: &cmds,
-	}, string(CmdNameFilterMatch), "")
-	flagSet.Var(&strCmdFlag{
-		name: CmdNameFilterUnMatch,
-		cmds: &cmds,
-	}, string(CmdNameFilterUnMatch), "")
-	flagSet.Var(&strCmdFlag{
-		name: CmdNameParent,
-		cmds: &cmds,
-	}, string(CmdNameParent), "")
-	flagSet.Var(&strCmdFlag{
-		name: CmdNameRx,
-		cmds: &cmds,
-	}, string(CmdNameRx), "")
-	flagSet.Var(&strCmdFlag{
-		name: CmdNameWrite,
-		cmds: &cmds,
-	}, string(CmdNameWrite), "")
-
-	if err := flagSet.Parse(args); err != nil {
+	parser := newCmdParser(&cmds)
+	for _, name := range []CmdName{
+		CmdNameMatch,
+		CmdNameFilterMatch,
+		CmdNameFilterUnMatch,
+		CmdNameParent,
+		CmdNameRx,
+		CmdNameWrite,
+		CmdNameSubstitute,
+		CmdNameAs,
+		CmdNameUnion,
+		CmdNameIntersect,
+		CmdNameSubtract,
+	} {
+		flagSet.Var(&strCmdFlag{
+			name:   name,
+			parser: parser,
+		}, string(name), "")
+	}
+	for _, name := range []CmdName{CmdNameOr, CmdNameAnd} {
+		flagSet.Var(&groupCmdFlag{
+			name:   name,
+			parser: parser,
+		}, string(name), "")
+	}
+
+	// The flags are parsed until the first argument that is neither a flag nor a group delimiter.
+	args, err := parseFlags(flagSet, parser, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(cmds) < 1 && len(ruleFiles) < 1 {
+		return nil, nil, fmt.Errorf("need at least one command or rule file")
+	}
+
+	if err := compileCmds(cmds); err != nil {
 		return nil, nil, err
 	}
 
-	if len(cmds) < 1 {
-		return nil, nil, fmt.Errorf("need at least one command")
+	var rules []Rule
+	for _, ruleFile := range ruleFiles {
+		fileRules, err := loadRules(ruleFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		rules = append(rules, fileRules...)
+	}
+	ids := map[string]bool{}
+	for _, rule := range rules {
+		if ids[rule.id] {
+			return nil, nil, fmt.Errorf("duplicate rule %q", rule.id)
+		}
+		ids[rule.id] = true
+	}
+
+	// The name of the last command, which is empty if there is only rule files.
+	var last CmdName
+	if len(cmds) != 0 {
+		last = cmds[len(cmds)-1].name
+	}
+	if len(rules) != 0 && last == CmdNameSubstitute {
+		return nil, nil, fmt.Errorf("`-f` cannot be used with `-%s`", CmdNameSubstitute)
 	}
 
+	if inplace && last != CmdNameSubstitute {
+		return nil, nil, fmt.Errorf("`-i` requires `-%s` to be the last command", CmdNameSubstitute)
+	}
+	if backup && !inplace {
+		return nil, nil, fmt.Errorf("`-backup` requires `-i`")
+	}
+	if diff && last != CmdNameSubstitute {
+		return nil, nil, fmt.Errorf("`-d` requires `-%s` to be the last command", CmdNameSubstitute)
+	}
+	if diff && inplace {
+		return nil, nil, fmt.Errorf("`-d` and `-i` are mutually exclusive")
+	}
+
+	if quiet && last == CmdNameSubstitute {
+		return nil, nil, fmt.Errorf("`-q` cannot be used with `-%s`", CmdNameSubstitute)
+	}
+
+	if jsonOutput {
+		format = FormatJSON
+	}
+	switch OutputFormat(format) {
+	case FormatText:
+	case FormatJSON, FormatSARIF:
+		if last == CmdNameSubstitute {
+			return nil, nil, fmt.Errorf("%s format cannot be used with `-%s`", format, CmdNameSubstitute)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown format %q, must be one of %q, %q and %q", format, FormatText, FormatJSON, FormatSARIF)
+	}
+
+	summary := SummaryNone
+	for _, opt := range []struct {
+		name    string
+		set     bool
+		summary Summary
+	}{
+		{"c", count, SummaryCount},
+		{"l", filesWithMatches, SummaryFilesWithMatches},
+		{"L", filesWithoutMatches, SummaryFilesWithoutMatches},
+	} {
+		if !opt.set {
+			continue
+		}
+		if summary != SummaryNone {
+			return nil, nil, fmt.Errorf("`-c`, `-l` and `-L` are mutually exclusive")
+		}
+		if last == CmdNameSubstitute {
+			return nil, nil, fmt.Errorf("`-%s` cannot be used with `-%s`", opt.name, CmdNameSubstitute)
+		}
+		if OutputFormat(format) != FormatText {
+			return nil, nil, fmt.Errorf("`-%s` cannot be used with %s format", opt.name, format)
+		}
+		summary = opt.summary
+	}
+
+	if vimgrep {
+		if last == CmdNameSubstitute {
+			return nil, nil, fmt.Errorf("`-vimgrep` cannot be used with `-%s`", CmdNameSubstitute)
+		}
+		if OutputFormat(format) != FormatText {
+			return nil, nil, fmt.Errorf("`-vimgrep` cannot be used with %s format", format)
+		}
+		if summary != SummaryNone {
+			return nil, nil, fmt.Errorf("`-vimgrep` cannot be used with `-c`, `-l` or `-L`")
+		}
+	}
+
+	// "-A" and "-B" take precedence over "-C".
+	for _, opt := range []struct {
+		name string
+		n    *int
+	}{
+		{"A", &after},
+		{"B", &before},
+		{"C", &context},
+	} {
+		if *opt.n < 0 {
+			return nil, nil, fmt.Errorf("the number follows `-%s` must >=0, got %d", opt.name, *opt.n)
+		}
+	}
+	setFlags := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if !setFlags["B"] {
+		before = context
+	}
+	if !setFlags["A"] {
+		after = context
+	}
+	if before != 0 || after != 0 {
+		if last == CmdNameSubstitute {
+			return nil, nil, fmt.Errorf("context lines cannot be used with `-%s`", CmdNameSubstitute)
+		}
+		if OutputFormat(format) != FormatText {
+			return nil, nil, fmt.Errorf("context lines cannot be used with %s format", format)
+		}
+		if summary != SummaryNone || vimgrep {
+			return nil, nil, fmt.Errorf("context lines cannot be used with `-c`, `-l`, `-L` or `-vimgrep`")
+		}
+	}
+
+	switch ColorMode(color) {
+	case ColorAuto, ColorAlways, ColorNever:
+	default:
+		return nil, nil, fmt.Errorf("unknown color mode %q, must be one of %q, %q and %q", color, ColorAuto, ColorAlways, ColorNever)
+	}
+
+	if jobs < 1 {
+		return nil, nil, fmt.Errorf("the number follows `-j` must >=1, got %d", jobs)
+	}
+
+	for _, glob := range append(includes, excludes...) {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, nil, fmt.Errorf("invalid glob %q: %v", glob, err)
+		}
+	}
+
+	opts := []Option{
+		OptionUnordered(unordered),
+		OptionPrefixPosition(prefix),
+		OptionVimgrep(vimgrep),
+		OptionContext(before, after),
+		OptionColor(ColorMode(color)),
+		OptionQuiet(quiet),
+		OptionWriteInPlace(inplace),
+		OptionBackup(backup),
+		OptionDiff(diff),
+		OptionInclude(includes),
+		OptionExclude(excludes),
+		OptionNoIgnore(noIgnore),
+		OptionJobs(jobs),
+		OptionFormat(OutputFormat(format)),
+		OptionSummary(summary),
+	}
+	for _, cmd := range cmds {
+		opts = append(opts, OptionCmd(cmd))
+	}
+	for _, rule := range rules {
+		opts = append(opts, OptionRule(rule))
+	}
+	return opts, args, nil
+}
+
+// parseFlags parses the flags, together with the group delimiters among them. It returns the remaining arguments.
+func parseFlags(flagSet *flag.FlagSet, parser *cmdParser, args []string) ([]string, error) {
+	for {
+		if err := flagSet.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flagSet.Args()
+		if len(args) == 0 || (args[0] != groupStart && args[0] != groupEnd) {
+			break
+		}
+		if err := parser.group(args[0]); err != nil {
+			return nil, err
+		}
+		args = args[1:]
+	}
+	if err := parser.done(); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// compileCmds compiles the source of each command to its value.
+func compileCmds(cmds []Cmd) error {
+	return compileCmdList(cmds, false, map[string]bool{})
+}
+
+// compileCmdList compiles the commands, which are the commands of a group if inGroup is true. The sets are the names of
+// the sets saved by the preceding commands.
+func compileCmdList(cmds []Cmd, inGroup bool, sets map[string]bool) error {
 	for i, cmd := range cmds {
 		switch cmd.name {
 		case CmdNameWrite:
+			if inGroup {
+				return fmt.Errorf("`-%s` cannot be used in a group", cmd.name)
+			}
 			if i != len(cmds)-1 {
-				return nil, nil, fmt.Errorf("`-%s` must be the last command", cmd.name)
+				return fmt.Errorf("`-%s` must be the last command", cmd.name)
 			}
 			cmds[i].value = CmdValueString(cmd.src)
+		case CmdNameSubstitute:
+			if inGroup {
+				return fmt.Errorf("`-%s` cannot be used in a group", cmd.name)
+			}
+			if i != len(cmds)-1 {
+				return fmt.Errorf("`-%s` must be the last command", cmd.name)
+			}
+			tmpl, err := compileTemplate(cmd.src)
+			if err != nil {
+				return err
+			}
+			cmds[i].value = CmdValueTemplate{tmpl}
 		case CmdNameRx:
 			name, rx, err := parseRegexpAttr(cmd.src)
 			if err != nil {
-				return nil, nil, err
+				return err
 			}
 			cmds[i].value = CmdValueRx{name: name, rx: *rx}
 		case CmdNameParent:
 			n, err := strconv.Atoi(cmd.src)
 			if err != nil {
-				return nil, nil, err
+				return err
 			}
 			if n < 0 {
-				return nil, nil, fmt.Errorf("the number follows `-%s` must >=0, got %d", cmd.name, n)
+				return fmt.Errorf("the number follows `-%s` must >=0, got %d", cmd.name, n)
 			}
 			cmds[i].value = CmdValueLevel(n)
+		case CmdNameOr, CmdNameAnd:
+			if len(cmd.groups) < 2 {
+				return fmt.Errorf("`-%s` must be followed by at least two groups", cmd.name)
+			}
+			for _, group := range cmd.groups {
+				if len(group) == 0 {
+					return fmt.Errorf("the groups of `-%s` must not be empty", cmd.name)
+				}
+				if err := compileCmdList(group, true, sets); err != nil {
+					return err
+				}
+			}
+		case CmdNameAs:
+			if cmd.src == "" {
+				return fmt.Errorf("the name follows `-%s` must not be empty", cmd.name)
+			}
+			sets[cmd.src] = true
+			cmds[i].value = CmdValueString(cmd.src)
+		case CmdNameUnion, CmdNameIntersect, CmdNameSubtract:
+			if !sets[cmd.src] {
+				return fmt.Errorf("unknown set %q, which must be saved by `-%s` before `-%s`", cmd.src, CmdNameAs, cmd.name)
+			}
+			cmds[i].value = CmdValueString(cmd.src)
 		default:
-			node, err := compileExpr(cmd.src)
+			node, wildcards, err := compileExpr(cmd.src)
 			if err != nil {
-				return nil, nil, err
+				return err
 			}
-			cmds[i].value = CmdValueNode{node}
+			cmds[i].value = CmdValueNode{Node: node, wildcards: wildcards}
 		}
 	}
-
-	opts := []Option{OptionPrefixPosition(prefix)}
-	for _, cmd := range cmds {
-		opts = append(opts, OptionCmd(cmd))
-	}
-	return opts, flagSet.Args(), nil
+	return nil
 }
 
 func parseAttr(attr string) (string, string, error) {
@@ -183,12 +557,16 @@ func parseRegexpAttr(attr string) (string, *regexp.Regexp, error) {
 	if err != nil {
 		return "", nil, fmt.Errorf("cannot parse attribute: %v", err)
 	}
-	if !strings.HasPrefix(value, "^") {
-		value = "^" + value
-	}
-	if !strings.HasSuffix(value, "$") {
-		value = value + "$"
-	}
-	rx, err := regexp.Compile(value)
+	rx, err := compileAnchored(value)
 	return name, rx, err
 }
+
+// compileAnchored compiles the regexp, which must match the whole literal, e.g. "22|\*" matches neither "2222" nor
+// "**".
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	// Report the error against the regexp as written.
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, err
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
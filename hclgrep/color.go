@@ -0,0 +1,140 @@
+package hclgrep
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type ColorMode string
+
+const (
+	ColorAuto   ColorMode = "auto"
+	ColorAlways           = "always"
+	ColorNever            = "never"
+)
+
+const (
+	colorReset = "\x1b[0m"
+	colorMatch = "\x1b[1;31m"
+)
+
+// wildcardColors are the colors of the recorded wildcards, which are assigned in the order of the wildcard names.
+var wildcardColors = []string{
+	"\x1b[1;32m",
+	"\x1b[1;33m",
+	"\x1b[1;34m",
+	"\x1b[1;35m",
+	"\x1b[1;36m",
+}
+
+// useColor tells whether to colorize the output, which is only the case for a terminal in the auto mode.
+func useColor(mode ColorMode, out io.Writer) bool {
+	switch mode {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	f, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize returns the source bytes between the start and end offsets. When colorizing is enabled, the bytes inside
+// the matched nodes of the submatches are highlighted, and those inside the recorded wildcard values are highlighted
+// with a distinct color per wildcard name.
+func (m *Matcher) colorize(start, end int, subs ...submatch) string {
+	if !m.color {
+		return string(m.b[start:end])
+	}
+
+	colors := make([]string, end-start)
+	paint := func(from, to int, color string) {
+		if from < start {
+			from = start
+		}
+		if to > end {
+			to = end
+		}
+		for i := from; i < to; i++ {
+			colors[i-start] = color
+		}
+	}
+
+	type wildcardRange struct {
+		name       string
+		start, end int
+	}
+	var wildcards []wildcardRange
+	nameSet := map[string]bool{}
+	for _, sub := range subs {
+		rng := sub.node.Range()
+		paint(rng.Start.Byte, rng.End.Byte, colorMatch)
+		for name, val := range sub.values {
+			vals := []substitution{val}
+			if _, ok := m.substitutionRange(val); !ok && val.List != nil {
+				// The elements that are not next to each other are painted on their own.
+				vals = val.List
+			}
+			for _, val := range vals {
+				rng, ok := m.substitutionRange(val)
+				if !ok {
+					rng, ok = m.labelRange(val)
+				}
+				if ok {
+					wildcards = append(wildcards, wildcardRange{name: name, start: rng.Start.Byte, end: rng.End.Byte})
+					nameSet[name] = true
+				}
+			}
+		}
+	}
+	var names []string
+	for name := range nameSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	nameColors := map[string]string{}
+	for i, name := range names {
+		nameColors[name] = wildcardColors[i%len(wildcardColors)]
+	}
+	// Paint the larger wildcard values first, so that the nested ones remain visible.
+	sort.SliceStable(wildcards, func(i, j int) bool {
+		return wildcards[i].end-wildcards[i].start > wildcards[j].end-wildcards[j].start
+	})
+	for _, w := range wildcards {
+		paint(w.start, w.end, nameColors[w.name])
+	}
+
+	var sb strings.Builder
+	var cur string
+	for i, b := range m.b[start:end] {
+		color := colors[i]
+		// Reset before each newline, so that each line is colorized on its own.
+		if b == '\n' {
+			color = ""
+		}
+		if color != cur {
+			if cur != "" {
+				sb.WriteString(colorReset)
+			}
+			sb.WriteString(color)
+			cur = color
+		}
+		sb.WriteByte(b)
+	}
+	if cur != "" {
+		sb.WriteString(colorReset)
+	}
+	return sb.String()
+}
@@ -0,0 +1,91 @@
+package hclgrep
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// lineWindow is a range of 1-based lines (inclusive) to output.
+type lineWindow struct {
+	start, end int
+}
+
+// outputContext outputs the lines of the matches together with the context lines around them, in the way of grep:
+// each line is prefixed with its line number, followed by ":" for the matched lines and "-" for the context lines.
+// The overlapping (or adjacent) windows are merged, while the others are separated by "--".
+func (m *Matcher) outputContext(fileName string, subs []submatch) error {
+	if len(subs) == 0 {
+		return nil
+	}
+	lines := splitLines(m.b)
+	// lineStarts are the byte offsets of the lines.
+	lineStarts := make([]int, len(lines))
+	for i := 1; i < len(lines); i++ {
+		lineStarts[i] = lineStarts[i-1] + len(lines[i-1])
+	}
+
+	matched := map[int]bool{}
+	// tags are the rule tags of the matches, keyed by the first line of the matches.
+	tags := map[int]string{}
+	var windows []lineWindow
+	for _, sub := range subs {
+		// Fallback to the matched node for the wildcard value that has no range (e.g. a block label).
+		rng := sub.node.Range()
+		if r, ok := m.substitutionRange(m.target(sub)); ok {
+			rng = r
+		}
+		if _, ok := tags[rng.Start.Line]; !ok {
+			tags[rng.Start.Line] = ruleTag(sub)
+		}
+		for l := rng.Start.Line; l <= rng.End.Line; l++ {
+			matched[l] = true
+		}
+		w := lineWindow{start: rng.Start.Line - m.before, end: rng.End.Line + m.after}
+		if w.start < 1 {
+			w.start = 1
+		}
+		if w.end > len(lines) {
+			w.end = len(lines)
+		}
+		windows = append(windows, w)
+	}
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i].start < windows[j].start })
+	merged := windows[:1]
+	for _, w := range windows[1:] {
+		last := &merged[len(merged)-1]
+		if w.start > last.end+1 {
+			merged = append(merged, w)
+			continue
+		}
+		if w.end > last.end {
+			last.end = w.end
+		}
+	}
+
+	name := displayName(fileName)
+	for i, w := range merged {
+		if i != 0 {
+			if _, err := fmt.Fprintln(m.out, "--"); err != nil {
+				return err
+			}
+		}
+		for l := w.start; l <= w.end; l++ {
+			sep := "-"
+			if matched[l] {
+				sep = ":"
+			}
+			head := fmt.Sprintf("%d%s", l, sep)
+			if m.prefix {
+				head = name + sep + head
+			}
+			start := lineStarts[l-1]
+			end := start + len(strings.TrimSuffix(lines[l-1], "\n"))
+			if _, err := fmt.Fprintf(m.out, "%s%s%s\n", head, tags[l], m.colorize(start, end, subs...)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package hclgrep
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContext is the number of context lines around the changes in a hunk.
+const diffContext = 3
+
+// diffOp is one line of the edit script that turns the old content to the new content.
+type diffOp struct {
+	// kind is one of ' ' (equal), '-' (delete) and '+' (insert).
+	kind byte
+	line string
+	// a and b are the indexes of the line in the old and the new content respectively. For an insertion (deletion),
+	// a (b) is the index of the old (new) line that the line is inserted (deleted) before.
+	a, b int
+}
+
+// writeUnifiedDiff writes the unified diff between the old and the new content to w.
+func writeUnifiedDiff(w io.Writer, fileName string, old, new []byte) error {
+	ops := diffLines(splitLines(old), splitLines(new))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fileName, fileName)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk as long as the next change is close enough to share the context.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops) && j <= end+2*diffContext+1; j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			}
+		}
+		i = end + 1
+		end += diffContext
+		if end >= len(ops) {
+			end = len(ops) - 1
+		}
+
+		var aCount, bCount int
+		for _, op := range ops[start : end+1] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(ops[start].a, aCount), hunkRange(ops[start].b, bCount))
+		for _, op := range ops[start : end+1] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// hunkRange formats the line range of a hunk, where start is the 0-based index of its first line.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		// An empty range refers to the line before it.
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// splitLines splits the content into lines, each (except maybe the last one) ends with a newline.
+func splitLines(b []byte) []string {
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the shortest edit script between two list of lines, via the Myers' algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace records the furthest reaching x of each diagonal k before each round d, where only the diagonals
+	// [-d-1, d+1] (i.e. the ones reachable by the round) are recorded, so that the memory is O(D^2).
+	var trace [][]int
+outer:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break outer
+			}
+		}
+	}
+
+	// Backtrack the trace to build the edit script, in reverse order.
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		// v[d+1+k] is the furthest reaching x of the diagonal k
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[d+1+k-1] < v[d+1+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[d+1+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{kind: ' ', line: a[x], a: x, b: y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, diffOp{kind: '+', line: b[y], a: x, b: y})
+			} else {
+				x--
+				ops = append(ops, diffOp{kind: '-', line: a[x], a: x, b: y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
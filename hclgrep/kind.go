@@ -0,0 +1,181 @@
+package hclgrep
+
+import (
+	"sort"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// kindFunc tells whether a wildcard value is of a kind.
+type kindFunc func(val substitution) bool
+
+// exprKinds are the kinds that an expression wildcard can be constrained to, e.g. $x:string
+var exprKinds = map[string]kindFunc{
+	"string":      literalKind(cty.String),
+	"number":      literalKind(cty.Number),
+	"bool":        literalKind(cty.Bool),
+	"null":        isNull,
+	"template":    nodeKind(isTemplate),
+	"traversal":   nodeKind(isTraversal),
+	"call":        nodeKind(func(node hclsyntax.Node) bool { _, ok := node.(*hclsyntax.FunctionCallExpr); return ok }),
+	"tuple":       nodeKind(func(node hclsyntax.Node) bool { _, ok := node.(*hclsyntax.TupleConsExpr); return ok }),
+	"object":      nodeKind(func(node hclsyntax.Node) bool { _, ok := node.(*hclsyntax.ObjectConsExpr); return ok }),
+	"for":         nodeKind(func(node hclsyntax.Node) bool { _, ok := node.(*hclsyntax.ForExpr); return ok }),
+	"conditional": nodeKind(func(node hclsyntax.Node) bool { _, ok := node.(*hclsyntax.ConditionalExpr); return ok }),
+	"index":       nodeKind(func(node hclsyntax.Node) bool { _, ok := node.(*hclsyntax.IndexExpr); return ok }),
+	"splat":       nodeKind(func(node hclsyntax.Node) bool { _, ok := node.(*hclsyntax.SplatExpr); return ok }),
+	"operation":   nodeKind(isOperation),
+}
+
+// attrKinds are the kinds that an attribute wildcard can be constrained to, e.g. @x:block
+var attrKinds = map[string]kindFunc{
+	"attribute": func(val substitution) bool {
+		if val.ObjectConsItem != nil {
+			return true
+		}
+		_, ok := val.Node.(*hclsyntax.Attribute)
+		return ok
+	},
+	"block": nodeKind(func(node hclsyntax.Node) bool { _, ok := node.(*hclsyntax.Block); return ok }),
+}
+
+// opKinds are the kinds (i.e. the classes of operators) that an operator wildcard can be constrained to, e.g. $op:cmp
+var opKinds = map[string]kindFunc{
+	"cmp": operatorKind(
+		hclsyntax.OpEqual,
+		hclsyntax.OpNotEqual,
+		hclsyntax.OpGreaterThan,
+		hclsyntax.OpGreaterThanOrEqual,
+		hclsyntax.OpLessThan,
+		hclsyntax.OpLessThanOrEqual,
+	),
+	"arith": operatorKind(
+		hclsyntax.OpAdd,
+		hclsyntax.OpSubtract,
+		hclsyntax.OpMultiply,
+		hclsyntax.OpDivide,
+		hclsyntax.OpModulo,
+		hclsyntax.OpNegate,
+	),
+	"logic": operatorKind(
+		hclsyntax.OpLogicalAnd,
+		hclsyntax.OpLogicalOr,
+		hclsyntax.OpLogicalNot,
+	),
+}
+
+// opSymbols are the symbols of the operators.
+var opSymbols = map[*hclsyntax.Operation]string{
+	hclsyntax.OpEqual:              "==",
+	hclsyntax.OpNotEqual:           "!=",
+	hclsyntax.OpGreaterThan:        ">",
+	hclsyntax.OpGreaterThanOrEqual: ">=",
+	hclsyntax.OpLessThan:           "<",
+	hclsyntax.OpLessThanOrEqual:    "<=",
+	hclsyntax.OpAdd:                "+",
+	hclsyntax.OpSubtract:           "-",
+	hclsyntax.OpMultiply:           "*",
+	hclsyntax.OpDivide:             "/",
+	hclsyntax.OpModulo:             "%",
+	hclsyntax.OpNegate:             "-",
+	hclsyntax.OpLogicalAnd:         "&&",
+	hclsyntax.OpLogicalOr:          "||",
+	hclsyntax.OpLogicalNot:         "!",
+}
+
+// isKindName reports whether the name is the name of any kind.
+func isKindName(name string) bool {
+	for _, kinds := range []map[string]kindFunc{exprKinds, opKinds, attrKinds} {
+		if _, ok := kinds[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// kindNames returns the sorted names of the kinds.
+func kindNames(kindSets ...map[string]kindFunc) []string {
+	var names []string
+	for _, kinds := range kindSets {
+		for name := range kinds {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func nodeKind(f func(node hclsyntax.Node) bool) kindFunc {
+	return func(val substitution) bool {
+		return val.Node != nil && f(val.Node)
+	}
+}
+
+// literalKind returns the kind of the literal values of the type. The string kind also includes the template without
+// any interpolation or directive, and the string in the place that a string is accepted (e.g. a block label).
+func literalKind(ty cty.Type) kindFunc {
+	return func(val substitution) bool {
+		if val.String != nil {
+			return ty == cty.String
+		}
+		switch node := val.Node.(type) {
+		case *hclsyntax.LiteralValueExpr:
+			return !node.Val.IsNull() && node.Val.Type() == ty
+		case *hclsyntax.TemplateExpr:
+			if ty != cty.String || len(node.Parts) != 1 {
+				return false
+			}
+			_, ok := node.Parts[0].(*hclsyntax.LiteralValueExpr)
+			return ok
+		default:
+			return false
+		}
+	}
+}
+
+func operatorKind(ops ...*hclsyntax.Operation) kindFunc {
+	return func(val substitution) bool {
+		if val.Operator == nil {
+			return false
+		}
+		for _, op := range ops {
+			if val.Operator.op == op {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func isNull(val substitution) bool {
+	node, ok := val.Node.(*hclsyntax.LiteralValueExpr)
+	return ok && node.Val.IsNull()
+}
+
+func isTemplate(node hclsyntax.Node) bool {
+	switch node.(type) {
+	case *hclsyntax.TemplateExpr, *hclsyntax.TemplateWrapExpr:
+		return true
+	default:
+		return false
+	}
+}
+
+func isTraversal(node hclsyntax.Node) bool {
+	switch node.(type) {
+	case *hclsyntax.ScopeTraversalExpr, *hclsyntax.RelativeTraversalExpr:
+		return true
+	default:
+		return false
+	}
+}
+
+func isOperation(node hclsyntax.Node) bool {
+	switch node.(type) {
+	case *hclsyntax.BinaryOpExpr, *hclsyntax.UnaryOpExpr:
+		return true
+	default:
+		return false
+	}
+}
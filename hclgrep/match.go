@@ -1,12 +1,16 @@
 package hclgrep
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/zclconf/go-cty/cty"
 
@@ -19,15 +23,83 @@ type Matcher struct {
 
 	cmds []Cmd
 
+	// the rules run against each file, the first of which is made up of the cmds (if any)
+	rules []Rule
+
+	// whether match the elements of bodies and object constructors regardless of their order
+	unordered bool
+
+	// per file states, which are reset for each file processed by Files
 	parents map[hclsyntax.Node]hclsyntax.Node
 	b       []byte
+	root    hclsyntax.Node
+
+	// the named sets of submatches saved by "-as", which are reset for each rule
+	sets map[string][]submatch
 
 	// whether prefix the matches with filenname and byte offset
 	prefix bool
 
+	// whether output one line per match in the form of "file:line:column: text"
+	vimgrep bool
+
+	// number of context lines to output before and after each match
+	before int
+	after  int
+
+	// whether write the substituted content back to the file, instead of the out
+	inplace bool
+
+	// whether keep a backup of the original file when writing in place
+	backup bool
+
+	// whether defer writing in place to the caller, which then writes the substituted content kept in substituted
+	// (nil if no substitution), so that Files can stop writing at the first failed file
+	deferWrite  bool
+	substituted []byte
+
+	// whether print the unified diff of the substituted content, instead of the content
+	diff bool
+
+	// number of files that have diff
+	diffs int
+
+	// number of the final matches
+	matchCount int
+
+	// whether suppress the output and stop at the first match
+	quiet bool
+
+	// file name globs to select (or skip) files when walking directories
+	includes []string
+	excludes []string
+
+	// whether walk into the VCS, vendor and .terraform directories
+	noIgnore bool
+
+	// number of files processed concurrently
+	jobs int
+
+	// the output format of the matches
+	format OutputFormat
+
+	// how the final matches of each file are summarized, if any
+	summary Summary
+
+	// when to colorize the output, and whether it is colorized, which is decided by the color mode and the out
+	colorMode ColorMode
+	color     bool
+
+	// SARIF results of the processed files, which are written at the end of Files
+	sarifResults []sarifResult
+
 	// node values recorded by name, excluding "_" (used only by the
 	// actual matching phase)
 	values map[string]substitution
+
+	// the wildcard table of the pattern being matched (used only by the
+	// actual matching phase)
+	wildcards []wildcard
 }
 
 func NewMatcher(opts ...Option) Matcher {
@@ -35,32 +107,163 @@ func NewMatcher(opts ...Option) Matcher {
 	for _, opt := range opts {
 		opt(&m)
 	}
+	if len(m.cmds) != 0 {
+		m.rules = append([]Rule{{severity: SeverityWarning, cmds: m.cmds}}, m.rules...)
+	}
 	if m.out == nil {
 		m.out = os.Stdout
 	}
+	m.color = useColor(m.colorMode, m.out)
 	return m
 }
 
+// ErrDiff is returned by Matcher.Files in diff mode, when any file has diff.
+var ErrDiff = errors.New("diff found")
+
 // Files matches multiple Files, output the final matches to matcher's out. In case the length of the files is 0, it matches the content from the stdin.
-func (m *Matcher) Files(files []string) error {
+// The directories are walked recursively for the files selected by the include/exclude globs.
+// It returns the number of the final matches. In quiet mode, it stops at the first file that has any match.
+// In diff mode, it returns ErrDiff if any file has diff.
+func (m *Matcher) Files(files []string) (int, error) {
 	if len(files) == 0 {
+		if m.inplace {
+			return 0, fmt.Errorf("cannot write in place when reading from stdin")
+		}
 		if err := m.File("stdin", os.Stdin); err != nil {
-			return err
+			return m.matchCount, err
 		}
 	}
 
-	for _, file := range files {
-		in, err := os.Open(file)
-		if err != nil {
-			return fmt.Errorf("openning %s: %w", file, err)
+	files, err := m.expandFiles(files)
+	if err != nil {
+		return m.matchCount, fmt.Errorf("walking directories: %w", err)
+	}
+
+	// The files are processed concurrently by the workers, while their outputs are written in the order of the files.
+	results := make([]*fileResult, len(files))
+	for i := range results {
+		results[i] = &fileResult{done: make(chan struct{})}
+	}
+	// No more file is handed out once any file fails, as the sequential processing stops at the first failed file.
+	var failed int32
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		sem := make(chan struct{}, m.workers())
+		for i, file := range files {
+			select {
+			case sem <- struct{}{}:
+			case <-stop:
+				return
+			}
+			if atomic.LoadInt32(&failed) != 0 {
+				return
+			}
+			go func(file string, res *fileResult) {
+				defer func() { <-sem }()
+				defer close(res.done)
+				m.processFile(file, res)
+				if res.err != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}(file, results[i])
 		}
-		err = m.File(file, in)
-		in.Close()
-		if err != nil {
-			return fmt.Errorf("processing %s: %w", file, err)
+	}()
+
+	// Not accumulate to m.diffs (m.sarifResults) directly, as the matcher is being copied by the workers.
+	var (
+		diffs        = m.diffs
+		matchCount   = m.matchCount
+		sarifResults []sarifResult
+	)
+	for _, res := range results {
+		if m.quiet && matchCount != 0 {
+			break
+		}
+		<-res.done
+		if _, err := m.out.Write(res.out.Bytes()); err != nil {
+			return matchCount, err
+		}
+		diffs += res.diffs
+		matchCount += res.matchCount
+		sarifResults = append(sarifResults, res.sarifResults...)
+		if res.err != nil {
+			return matchCount, res.err
+		}
+		// The files are written in order, so that none is written after a failed one.
+		if res.substituted != nil {
+			if err := m.writeFile(res.file, res.orig, res.substituted); err != nil {
+				return matchCount, fmt.Errorf("processing %s: %w", res.file, err)
+			}
 		}
 	}
-	return nil
+	if m.format == FormatSARIF && !m.quiet {
+		if err := m.writeSARIF(append(m.sarifResults, sarifResults...)); err != nil {
+			return matchCount, err
+		}
+	}
+	if diffs != 0 {
+		return matchCount, ErrDiff
+	}
+	return matchCount, nil
+}
+
+// fileResult is the result of processing one file.
+type fileResult struct {
+	done chan struct{}
+	file string
+	out  bytes.Buffer
+	// the original and the substituted content to be written in place, if any
+	orig         []byte
+	substituted  []byte
+	diffs        int
+	matchCount   int
+	sarifResults []sarifResult
+	err          error
+}
+
+// processFile processes one file with a copy of the matcher, so that it can be run concurrently with other files.
+func (m *Matcher) processFile(file string, res *fileResult) {
+	// Read the whole file at first, so that the file is closed before it is (possibly) written in place.
+	b, err := os.ReadFile(file)
+	if err != nil {
+		res.err = fmt.Errorf("openning %s: %w", file, err)
+		return
+	}
+	res.file = file
+	fm := m.fork(&res.out)
+	fm.deferWrite = true
+	if err := fm.File(file, bytes.NewReader(b)); err != nil {
+		res.err = fmt.Errorf("processing %s: %w", file, err)
+	}
+	res.orig = fm.b
+	res.substituted = fm.substituted
+	res.diffs = fm.diffs
+	res.matchCount = fm.matchCount
+	res.sarifResults = fm.sarifResults
+}
+
+// fork returns a copy of the matcher with the per file states reset, whose output is written to out.
+func (m *Matcher) fork(out io.Writer) *Matcher {
+	fm := *m
+	fm.out = out
+	fm.parents = nil
+	fm.b = nil
+	fm.root = nil
+	fm.sets = nil
+	fm.values = nil
+	fm.substituted = nil
+	fm.diffs = 0
+	fm.matchCount = 0
+	fm.sarifResults = nil
+	return &fm
+}
+
+func (m *Matcher) workers() int {
+	if m.jobs < 1 {
+		return 1
+	}
+	return m.jobs
 }
 
 // File matches one File, output the final matches to matcher's out.
@@ -75,33 +278,41 @@ func (m *Matcher) File(fileName string, in io.Reader) error {
 	if diags.HasErrors() {
 		return fmt.Errorf("cannot parse source: %s", diags.Error())
 	}
-	matches := m.matches(f.Body.(*hclsyntax.Body))
-	wd, _ := os.Getwd()
-
-	if m.cmds[len(m.cmds)-1].name == CmdNameWrite {
+	subs := m.finalSubmatches(f.Body.(*hclsyntax.Body))
+	m.matchCount += len(subs)
+	if m.quiet {
 		return nil
 	}
 
-	for _, n := range matches {
-		rng := n.Range()
-		output := string(rng.SliceBytes(m.b))
-		if m.prefix {
-			if strings.HasPrefix(rng.Filename, wd) {
-				rng.Filename = rng.Filename[len(wd)+1:]
+	if cmd, ok := m.lastCmd(); ok && cmd.name == CmdNameSubstitute {
+		b, err := m.substitute(cmd.value.Value().(template), subs)
+		if err != nil {
+			return err
+		}
+		if m.inplace {
+			if m.deferWrite {
+				m.substituted = b
+				return nil
 			}
-			output = fmt.Sprintf("%s:\n%s", rng, output)
+			return m.writeFile(fileName, m.b, b)
 		}
-
-		fmt.Fprintf(m.out, "%s\n", output)
+		if m.diff {
+			if bytes.Equal(b, m.b) {
+				return nil
+			}
+			m.diffs++
+			return writeUnifiedDiff(m.out, fileName, m.b, b)
+		}
+		_, err = m.out.Write(b)
+		return err
 	}
-	return nil
+
+	return m.output(fileName, subs)
 }
 
 // matches matches one node.
 func (m *Matcher) matches(node hclsyntax.Node) []hclsyntax.Node {
-	m.fillParents(node)
-	initial := []submatch{{node: node, values: map[string]substitution{}}}
-	final := m.submatches(m.cmds, initial)
+	final := m.finalSubmatches(node)
 	matches := make([]hclsyntax.Node, len(final))
 	for i := range matches {
 		matches[i] = final[i].node
@@ -109,6 +320,30 @@ func (m *Matcher) matches(node hclsyntax.Node) []hclsyntax.Node {
 	return matches
 }
 
+// lastCmd returns the last command, if any.
+func (m *Matcher) lastCmd() (Cmd, bool) {
+	if len(m.cmds) == 0 {
+		return Cmd{}, false
+	}
+	return m.cmds[len(m.cmds)-1], true
+}
+
+// finalSubmatches runs each rule against one node, returns the final submatches grouped by the rules in order.
+func (m *Matcher) finalSubmatches(node hclsyntax.Node) []submatch {
+	m.fillParents(node)
+	m.root = node
+	var final []submatch
+	for i := range m.rules {
+		m.sets = map[string][]submatch{}
+		initial := []submatch{{node: node, values: map[string]substitution{}}}
+		for _, sub := range m.submatches(m.rules[i].cmds, initial) {
+			sub.rule = &m.rules[i]
+			final = append(final, sub)
+		}
+	}
+	return final
+}
+
 type parentsWalker struct {
 	stack   []hclsyntax.Node
 	parents map[hclsyntax.Node]hclsyntax.Node
@@ -151,6 +386,9 @@ func (m *Matcher) fillParents(nodes ...hclsyntax.Node) {
 type submatch struct {
 	node   hclsyntax.Node
 	values map[string]substitution
+
+	// the rule that the submatch is found by, which is only set for the final submatches
+	rule *Rule
 }
 
 func (m *Matcher) submatches(cmds []Cmd, subs []submatch) []submatch {
@@ -172,6 +410,20 @@ func (m *Matcher) submatches(cmds []Cmd, subs []submatch) []submatch {
 		fn = m.cmdRx
 	case CmdNameWrite:
 		fn = m.cmdWrite
+	case CmdNameSubstitute:
+		fn = m.cmdSubstitute
+	case CmdNameOr:
+		fn = m.cmdOr
+	case CmdNameAnd:
+		fn = m.cmdAnd
+	case CmdNameAs:
+		fn = m.cmdAs
+	case CmdNameUnion:
+		fn = m.cmdUnion
+	case CmdNameIntersect:
+		fn = m.cmdIntersect
+	case CmdNameSubtract:
+		fn = m.cmdSubtract
 	default:
 		panic(fmt.Sprintf("unknown command: %q", cmd.name))
 	}
@@ -179,6 +431,7 @@ func (m *Matcher) submatches(cmds []Cmd, subs []submatch) []submatch {
 }
 
 func (m *Matcher) cmdMatch(cmd Cmd, subs []submatch) []submatch {
+	m.wildcards = cmd.value.(CmdValueNode).wildcards
 	var matches []submatch
 	for _, sub := range subs {
 		hclsyntax.VisitAll(sub.node, func(node hclsyntax.Node) hcl.Diagnostics {
@@ -197,6 +450,7 @@ func (m *Matcher) cmdMatch(cmd Cmd, subs []submatch) []submatch {
 
 func (m *Matcher) cmdFilter(wantMatch bool) func(Cmd, []submatch) []submatch {
 	return func(cmd Cmd, subs []submatch) []submatch {
+		m.wildcards = cmd.value.(CmdValueNode).wildcards
 		var matches []submatch
 		var any bool
 		for _, sub := range subs {
@@ -246,92 +500,241 @@ func (m *Matcher) cmdRx(cmd Cmd, subs []submatch) []submatch {
 		if !ok {
 			continue
 		}
-		var valLit string
-		switch {
-		case val.String != nil:
-			valLit = *val.String
-		case val.Node != nil:
-			var ok bool
-			// check whether the node is a variable
-			valLit, ok = variableExpr(val.Node)
-			if !ok {
-				switch node := val.Node.(type) {
-				case *hclsyntax.TemplateExpr:
-					if len(node.Parts) != 1 {
-						continue
-					}
-					tmpl := node.Parts[0]
-					lve, ok := tmpl.(*hclsyntax.LiteralValueExpr)
-					if !ok {
-						continue
-					}
-					value, _ := lve.Value(nil)
-					valLit = value.AsString()
-				case *hclsyntax.LiteralValueExpr:
-					value, _ := node.Value(nil)
-					switch value.Type() {
-					case cty.String:
-						valLit = value.AsString()
-					case cty.Bool:
-						valLit = "true"
-						if value.False() {
-							valLit = "false"
-						}
-					case cty.Number:
-						// TODO: handle float?
-						valLit = value.AsBigFloat().String()
-					}
+		valLit, ok := substitutionLiteral(val)
+		if !ok {
+			continue
+		}
+		if rx.rx.MatchString(valLit) {
+			newsubs = append(newsubs, sub)
+		}
+	}
+	return newsubs
+}
+
+// substitutionLiteral returns the literal value of the substitution, which is matched by the regexps (i.e. "-rx" and the
+// wildcard constraints). For a variable, it is the variable name. For an attribute (block), it is the attribute name
+// (block type). For a list, it is the literals of the elements joined by ",".
+func substitutionLiteral(val substitution) (string, bool) {
+	var valLit string
+	switch {
+	case val.String != nil:
+		valLit = *val.String
+	case val.Node != nil:
+		var ok bool
+		// check whether the node is a variable
+		valLit, ok = variableExpr(val.Node)
+		if !ok {
+			switch node := val.Node.(type) {
+			case *hclsyntax.Attribute:
+				valLit = node.Name
+			case *hclsyntax.Block:
+				valLit = node.Type
+			case *hclsyntax.TemplateExpr:
+				if len(node.Parts) != 1 {
+					return "", false
 				}
+				tmpl := node.Parts[0]
+				lve, ok := tmpl.(*hclsyntax.LiteralValueExpr)
+				if !ok {
+					return "", false
+				}
+				value, _ := lve.Value(nil)
+				valLit = value.AsString()
+			case *hclsyntax.LiteralValueExpr:
+				value, _ := node.Value(nil)
+				valLit = valueLiteral(value)
 			}
-		case val.ObjectConsItem != nil:
-		case val.Traverser != nil:
-			switch trav := (*val.Traverser).(type) {
-			case hcl.TraverseRoot:
-				valLit = trav.Name
-			case hcl.TraverseAttr:
-				valLit = trav.Name
-			default:
-				continue
-			}
+		}
+	case val.ObjectConsItem != nil:
+	case val.Traverser != nil:
+		switch trav := (*val.Traverser).(type) {
+		case hcl.TraverseRoot:
+			valLit = trav.Name
+		case hcl.TraverseAttr:
+			valLit = trav.Name
+		case hcl.TraverseIndex:
+			valLit = valueLiteral(trav.Key)
 		default:
-			panic("never reach here")
+			return "", false
+		}
+	case val.List != nil:
+		lits := make([]string, 0, len(val.List))
+		for _, elem := range val.List {
+			lit, ok := substitutionLiteral(elem)
+			if !ok {
+				return "", false
+			}
+			lits = append(lits, lit)
 		}
+		valLit = strings.Join(lits, ",")
+	case val.Operator != nil:
+		valLit = opSymbols[val.Operator.op]
+	default:
+		panic("never reach here")
+	}
+	return valLit, true
+}
 
-		if rx.rx.MatchString(valLit) {
-			newsubs = append(newsubs, sub)
+// valueLiteral returns the literal of a primitive value, or empty for the others.
+func valueLiteral(value cty.Value) string {
+	if value.IsNull() || !value.IsKnown() {
+		return ""
+	}
+	switch value.Type() {
+	case cty.String:
+		return value.AsString()
+	case cty.Bool:
+		if value.False() {
+			return "false"
 		}
+		return "true"
+	case cty.Number:
+		// TODO: handle float?
+		return value.AsBigFloat().String()
+	default:
+		return ""
 	}
-	return newsubs
 }
 
+// literalValue returns the value of the node, if it is a literal value or a template without any interpolation.
+func literalValue(node hclsyntax.Node) (cty.Value, bool) {
+	switch node := node.(type) {
+	case *hclsyntax.LiteralValueExpr:
+		return node.Val, true
+	case *hclsyntax.TemplateExpr:
+		if !node.IsStringLiteral() {
+			return cty.NilVal, false
+		}
+		v, diags := node.Value(nil)
+		return v, !diags.HasErrors()
+	default:
+		return cty.NilVal, false
+	}
+}
+
+// cmdWrite discards the submatches whose wildcard value is not recorded (or has no source representation). The
+// wildcard values are printed when outputting the file.
 func (m *Matcher) cmdWrite(cmd Cmd, subs []submatch) []submatch {
+	var newsubs []submatch
 	for _, sub := range subs {
 		name := string(cmd.value.Value().(CmdValueString))
 		val, ok := sub.values[name]
 		if !ok {
 			continue
 		}
-		switch {
-		case val.String != nil:
-			fmt.Fprintln(m.out, *val.String)
-		case val.Node != nil:
-			fmt.Fprintln(m.out, string(val.Node.Range().SliceBytes(m.b)))
-		case val.ObjectConsItem != nil:
-		case val.Traverser != nil:
-			switch trav := (*val.Traverser).(type) {
-			case hcl.TraverseRoot:
-				fmt.Fprintln(m.out, trav.Name)
-			case hcl.TraverseAttr:
-				fmt.Fprintln(m.out, trav.Name)
-			default:
+		if _, ok := m.substitutionBytes(val); !ok {
+			continue
+		}
+		newsubs = append(newsubs, sub)
+	}
+	return newsubs
+}
+
+// cmdSubstitute keeps the submatches as is. The substitution happens when outputting the file, as it needs the whole
+// file content.
+func (m *Matcher) cmdSubstitute(cmd Cmd, subs []submatch) []submatch {
+	return subs
+}
+
+// cmdOr runs each group of commands against the submatches, and returns the union of their results.
+func (m *Matcher) cmdOr(cmd Cmd, subs []submatch) []submatch {
+	var lists [][]submatch
+	for _, group := range cmd.groups {
+		lists = append(lists, m.submatches(group, subs))
+	}
+	return unionSubmatches(lists...)
+}
+
+// cmdAnd runs each group of commands against the submatches, and returns the intersection of their results.
+func (m *Matcher) cmdAnd(cmd Cmd, subs []submatch) []submatch {
+	matches := m.submatches(cmd.groups[0], subs)
+	for _, group := range cmd.groups[1:] {
+		matches = m.intersectSubmatches(matches, m.submatches(group, subs))
+	}
+	return matches
+}
+
+// cmdAs saves the submatches as a named set, and starts over from the whole file.
+func (m *Matcher) cmdAs(cmd Cmd, subs []submatch) []submatch {
+	m.sets[string(cmd.value.Value().(CmdValueString))] = subs
+	return []submatch{{node: m.root, values: map[string]substitution{}}}
+}
+
+func (m *Matcher) cmdUnion(cmd Cmd, subs []submatch) []submatch {
+	return unionSubmatches(subs, m.sets[string(cmd.value.Value().(CmdValueString))])
+}
+
+func (m *Matcher) cmdIntersect(cmd Cmd, subs []submatch) []submatch {
+	return m.intersectSubmatches(subs, m.sets[string(cmd.value.Value().(CmdValueString))])
+}
+
+func (m *Matcher) cmdSubtract(cmd Cmd, subs []submatch) []submatch {
+	set := map[hclsyntax.Node]bool{}
+	for _, sub := range m.sets[string(cmd.value.Value().(CmdValueString))] {
+		set[sub.node] = true
+	}
+	var newsubs []submatch
+	for _, sub := range subs {
+		if !set[sub.node] {
+			newsubs = append(newsubs, sub)
+		}
+	}
+	return newsubs
+}
+
+// unionSubmatches returns the submatches of all the lists in source order. Of the submatches of the same node, only the
+// first one (and its wildcard values) is kept.
+func unionSubmatches(lists ...[]submatch) []submatch {
+	seen := map[hclsyntax.Node]bool{}
+	var newsubs []submatch
+	for _, list := range lists {
+		for _, sub := range list {
+			if seen[sub.node] {
 				continue
 			}
-		default:
-			panic("never reach here")
+			seen[sub.node] = true
+			newsubs = append(newsubs, sub)
 		}
 	}
+	sort.SliceStable(newsubs, func(i, j int) bool {
+		return newsubs[i].node.Range().Start.Byte < newsubs[j].node.Range().Start.Byte
+	})
+	return newsubs
+}
 
-	return subs
+// intersectSubmatches returns the submatches whose node is also matched by the others, with the wildcard values of the
+// (first) other submatch of the same node added. The submatch is discarded if a wildcard has different values in them.
+func (m *Matcher) intersectSubmatches(subs, others []submatch) []submatch {
+	byNode := map[hclsyntax.Node]submatch{}
+	for _, other := range others {
+		if _, ok := byNode[other.node]; !ok {
+			byNode[other.node] = other
+		}
+	}
+	var newsubs []submatch
+	for _, sub := range subs {
+		other, ok := byNode[sub.node]
+		if !ok {
+			continue
+		}
+		values := valsCopy(sub.values)
+		consistent := true
+		for name, val := range other.values {
+			prev, ok := values[name]
+			if !ok {
+				values[name] = val
+				continue
+			}
+			if !m.substitutionEqual(prev, val) {
+				consistent = false
+				break
+			}
+		}
+		if consistent {
+			newsubs = append(newsubs, submatch{node: sub.node, values: values})
+		}
+	}
+	return newsubs
 }
 
 func (m *Matcher) parentOf(node hclsyntax.Node) hclsyntax.Node {
@@ -347,16 +750,96 @@ func valsCopy(values map[string]substitution) map[string]substitution {
 }
 
 type substitution struct {
-	String         *string
+	String *string
+	// LabelRange is the source range of the block label (or block type) that the String is taken from, if any.
+	LabelRange     *hcl.Range
 	Node           hclsyntax.Node
 	ObjectConsItem *hclsyntax.ObjectConsItem
 	Traverser      *hcl.Traverser
+	// List is the elements matched by an any wildcard, which is non-nil even if no element is matched.
+	List []substitution
+	// Operator is the operator matched by an operator wildcard.
+	Operator *operator
+}
+
+// operator is the operator of an operation, together with its source range.
+type operator struct {
+	op  *hclsyntax.Operation
+	rng hcl.Range
+}
+
+// substitutionBytes returns the source representation of the substitution.
+func (m *Matcher) substitutionBytes(val substitution) ([]byte, bool) {
+	switch {
+	case val.String != nil:
+		return []byte(*val.String), true
+	case val.Node != nil:
+		return val.Node.Range().SliceBytes(m.b), true
+	case val.ObjectConsItem != nil:
+		rng := hcl.RangeBetween(val.ObjectConsItem.KeyExpr.Range(), val.ObjectConsItem.ValueExpr.Range())
+		return rng.SliceBytes(m.b), true
+	case val.Traverser != nil:
+		switch trav := (*val.Traverser).(type) {
+		case hcl.TraverseRoot:
+			return []byte(trav.Name), true
+		case hcl.TraverseAttr:
+			return []byte(trav.Name), true
+		case hcl.TraverseIndex:
+			// The source of the index key, i.e. without the surrounding "[" and "]" (or the leading "." of the legacy
+			// index syntax).
+			b := trav.SrcRange.SliceBytes(m.b)
+			if len(b) == 0 {
+				return nil, false
+			}
+			return bytes.TrimSpace(bytes.TrimSuffix(b[1:], []byte("]"))), true
+		default:
+			return nil, false
+		}
+	case val.List != nil:
+		if rng, ok := m.substitutionRange(val); ok {
+			return rng.SliceBytes(m.b), true
+		}
+		// The elements are not next to each other in the source, whose own sources are joined instead: the elements
+		// without a range are strings (i.e. block labels) separated by spaces, the elements on the same line are
+		// separated by commas, the others are on their own lines with the indentation of the first element.
+		if len(val.List) == 0 {
+			return []byte{}, true
+		}
+		sep := []byte(" ")
+		if rng, ok := m.substitutionRange(val.List[0]); ok {
+			sep = []byte(", ")
+			for _, elem := range val.List[1:] {
+				if r, ok := m.substitutionRange(elem); ok && r.Start.Line != rng.Start.Line {
+					line := m.b[bytes.LastIndexByte(m.b[:rng.Start.Byte], '\n')+1 : rng.Start.Byte]
+					sep = append([]byte("\n"), line[:len(line)-len(bytes.TrimLeft(line, " \t"))]...)
+					break
+				}
+			}
+		}
+		var elems [][]byte
+		for _, elem := range val.List {
+			b, ok := m.substitutionBytes(elem)
+			if !ok {
+				return nil, false
+			}
+			elems = append(elems, b)
+		}
+		return bytes.Join(elems, sep), true
+	case val.Operator != nil:
+		return []byte(opSymbols[val.Operator.op]), true
+	default:
+		panic("never reach here")
+	}
 }
 
 func newStringSubstitution(s string) substitution {
 	return substitution{String: &s}
 }
 
+func newLabelSubstitution(label string, rng hcl.Range) substitution {
+	return substitution{String: &label, LabelRange: &rng}
+}
+
 func newNodeSubstitution(node hclsyntax.Node) substitution {
 	return substitution{Node: node}
 }
@@ -369,6 +852,17 @@ func newTraverserSubstitution(trav hcl.Traverser) substitution {
 	return substitution{Traverser: &trav}
 }
 
+func newOperatorSubstitution(op *hclsyntax.Operation, rng hcl.Range) substitution {
+	return substitution{Operator: &operator{op: op, rng: rng}}
+}
+
+func newListSubstitution(list []substitution) substitution {
+	if list == nil {
+		list = []substitution{}
+	}
+	return substitution{List: list}
+}
+
 func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 	if pattern == nil || node == nil {
 		return pattern == node
@@ -386,9 +880,16 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 		y, ok := node.(*hclsyntax.ObjectConsExpr)
 		return ok && m.objectConsItems(x.Items, y.Items)
 	case *hclsyntax.TemplateExpr:
+		if m.hasTextWildcard(x.Parts) {
+			parts, ok := templateParts(node)
+			return ok && m.templateParts(x.Parts, parts)
+		}
 		y, ok := node.(*hclsyntax.TemplateExpr)
 		return ok && m.exprs(x.Parts, y.Parts)
 	case *hclsyntax.FunctionCallExpr:
+		if x.Name == deepName && len(x.Args) == 1 {
+			return m.deep(x.Args[0], node, true)
+		}
 		y, ok := node.(*hclsyntax.FunctionCallExpr)
 		return ok &&
 			m.potentialWildcardIdentEqual(x.Name, y.Name) &&
@@ -400,29 +901,7 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 			m.potentialWildcardIdentEqual(x.ValVar, y.ValVar) &&
 			m.node(x.CollExpr, y.CollExpr) && m.node(x.KeyExpr, y.KeyExpr) && m.node(x.ValExpr, y.ValExpr) && m.node(x.CondExpr, y.CondExpr) && x.Group == y.Group
 	case *hclsyntax.IndexExpr:
-		// In case the index key of x is a wildcard, try to also match "y" even if it is not an IndexExpr
-		xname, ok := variableExpr(x.Key)
-		if ok && isWildName(xname) {
-			switch y := node.(type) {
-			case *hclsyntax.ScopeTraversalExpr:
-				l := len(y.Traversal)
-				ySourceTraversal := &hclsyntax.ScopeTraversalExpr{
-					Traversal: make(hcl.Traversal, l-1),
-				}
-				copy(ySourceTraversal.Traversal, y.Traversal[:l-1])
-				return m.node(x.Collection, ySourceTraversal) && m.wildcardMatchTraverse(xname, y.Traversal[l-1])
-			case *hclsyntax.IndexExpr:
-				return m.node(x.Collection, y.Collection) && m.wildcardMatchNode(xname, y.Key)
-			case *hclsyntax.RelativeTraversalExpr:
-				return m.node(x.Collection, y.Source) && len(y.Traversal) == 1 && m.wildcardMatchTraverse(xname, y.Traversal[0])
-			default:
-				return false
-			}
-		}
-
-		// Otherwise, regular match against the same type
-		y, ok := node.(*hclsyntax.IndexExpr)
-		return ok && m.node(x.Collection, y.Collection) && m.node(x.Key, y.Key)
+		return m.traversalExpr(x, node)
 	case *hclsyntax.SplatExpr:
 		y, ok := node.(*hclsyntax.SplatExpr)
 		return ok && m.node(x.Source, y.Source) && m.node(x.Each, y.Each) && m.node(x.Item, y.Item)
@@ -431,24 +910,21 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 		return ok && m.node(x.Expression, y.Expression)
 	case *hclsyntax.UnaryOpExpr:
 		y, ok := node.(*hclsyntax.UnaryOpExpr)
-		return ok && m.operation(x.Op, y.Op) && m.node(x.Val, y.Val)
+		return ok && m.operation(x.Op, y.Op, operatorRange(y.Op, y.SrcRange.Filename, y.SrcRange.Start)) && m.node(x.Val, y.Val)
 	case *hclsyntax.BinaryOpExpr:
 		y, ok := node.(*hclsyntax.BinaryOpExpr)
-		return ok && m.operation(x.Op, y.Op) && m.node(x.LHS, y.LHS) && m.node(x.RHS, y.RHS)
+		return ok && m.operation(x.Op, y.Op, operatorRange(y.Op, y.SrcRange.Filename, m.skipSpaces(y.LHS.Range().End))) && m.node(x.LHS, y.LHS) && m.node(x.RHS, y.RHS)
 	case *hclsyntax.ConditionalExpr:
 		y, ok := node.(*hclsyntax.ConditionalExpr)
 		return ok && m.node(x.Condition, y.Condition) && m.node(x.TrueResult, y.TrueResult) && m.node(x.FalseResult, y.FalseResult)
 	case *hclsyntax.ScopeTraversalExpr:
 		xname, ok := variableExpr(x)
 		if ok && isWildName(xname) {
-			name, _ := fromWildName(xname)
-			return m.wildcardMatchNode(name, node)
+			return m.wildcardMatchNode(xname, node)
 		}
-		y, ok := node.(*hclsyntax.ScopeTraversalExpr)
-		return ok && m.traversal(x.Traversal, y.Traversal)
+		return m.traversalExpr(x, node)
 	case *hclsyntax.RelativeTraversalExpr:
-		y, ok := node.(*hclsyntax.RelativeTraversalExpr)
-		return ok && m.traversal(x.Traversal, y.Traversal) && m.node(x.Source, y.Source)
+		return m.traversalExpr(x, node)
 	case *hclsyntax.ObjectConsKeyExpr:
 		y, ok := node.(*hclsyntax.ObjectConsKeyExpr)
 		return ok && m.node(x.Wrapped, y.Wrapped) && x.ForceNonLiteral == y.ForceNonLiteral
@@ -456,6 +932,10 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 		y, ok := node.(*hclsyntax.TemplateJoinExpr)
 		return ok && m.node(x.Tuple, y.Tuple)
 	case *hclsyntax.TemplateWrapExpr:
+		if m.hasTextWildcard([]hclsyntax.Expression{x.Wrapped}) {
+			parts, ok := templateParts(node)
+			return ok && m.templateParts([]hclsyntax.Expression{x.Wrapped}, parts)
+		}
 		y, ok := node.(*hclsyntax.TemplateWrapExpr)
 		return ok && m.node(x.Wrapped, y.Wrapped)
 	case *hclsyntax.AnonSymbolExpr:
@@ -471,6 +951,14 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 		return m.attribute(x, node)
 	// Block
 	case *hclsyntax.Block:
+		if elem, ok := wrappedElement(x, deepName); ok {
+			return m.deep(elem, node, false)
+		}
+		// A single negative element matches a body that has no element matching it.
+		if elem, ok := wrappedElement(x, notName); ok {
+			y, ok := node.(*hclsyntax.Body)
+			return ok && !m.present(elem, y, sortBody(y))
+		}
 		y, ok := node.(*hclsyntax.Block)
 		return ok && m.block(x, y)
 	default:
@@ -483,11 +971,15 @@ func (m *Matcher) node(pattern, node hclsyntax.Node) bool {
 }
 
 type matchFunc func(*Matcher, interface{}, interface{}) bool
+
+// wildNameFunc returns the wildcard ident of an element, and whether it is an any wildcard.
 type wildNameFunc func(interface{}) (string, bool)
 
 type iterable interface {
 	at(i int) interface{}
 	len() int
+	// substitution returns the substitution of the i-th element, which is recorded by the any wildcard.
+	substitution(i int) substitution
 }
 
 type stringIterable []string
@@ -499,6 +991,31 @@ func (it stringIterable) len() int {
 	return len(it)
 }
 
+func (it stringIterable) substitution(i int) substitution {
+	return newStringSubstitution(it[i])
+}
+
+// labelIterable is the labels of a block, together with their source ranges.
+type labelIterable struct {
+	labels []string
+	ranges []hcl.Range
+}
+
+func (it labelIterable) at(i int) interface{} {
+	return it.substitution(i)
+}
+
+func (it labelIterable) len() int {
+	return len(it.labels)
+}
+
+func (it labelIterable) substitution(i int) substitution {
+	if i >= len(it.ranges) {
+		return newStringSubstitution(it.labels[i])
+	}
+	return newLabelSubstitution(it.labels[i], it.ranges[i])
+}
+
 type nodeIterable []hclsyntax.Node
 
 func (it nodeIterable) at(i int) interface{} {
@@ -509,6 +1026,10 @@ func (it nodeIterable) len() int {
 	return len(it)
 }
 
+func (it nodeIterable) substitution(i int) substitution {
+	return newNodeSubstitution(it[i])
+}
+
 type exprIterable []hclsyntax.Expression
 
 func (it exprIterable) at(i int) interface{} {
@@ -519,6 +1040,10 @@ func (it exprIterable) len() int {
 	return len(it)
 }
 
+func (it exprIterable) substitution(i int) substitution {
+	return newNodeSubstitution(it[i])
+}
+
 type objectConsItemIterable []hclsyntax.ObjectConsItem
 
 func (it objectConsItemIterable) at(i int) interface{} {
@@ -529,53 +1054,130 @@ func (it objectConsItemIterable) len() int {
 	return len(it)
 }
 
-// iterableMatches matches two lists. It uses a common algorithm to match
-// wildcard patterns with any number of elements without recursion.
+func (it objectConsItemIterable) substitution(i int) substitution {
+	return newObjectConsItemSubstitution(&it[i])
+}
+
+// iterableMatches matches two lists. Each any wildcard of ns1 matches a sub-sequence of ns2, which is recorded as a
+// list. The shorter sub-sequences are tried first, and it backtracks to try the longer ones if the matching of the
+// following elements fails.
 func (m *Matcher) iterableMatches(ns1, ns2 iterable, nf wildNameFunc, mf matchFunc) bool {
-	i1, i2 := 0, 0
-	next1, next2 := 0, 0
-
-	// We need to keep a copy of m.values so that we can restart
-	// with a different "any of" match while discarding any matches
-	// we found while trying it.
-	var oldMatches map[string]substitution
-	backupMatches := func() {
-		oldMatches = make(map[string]substitution, len(m.values))
-		for k, v := range m.values {
-			oldMatches[k] = v
-		}
-	}
-	backupMatches()
-
-	for i1 < ns1.len() || i2 < ns2.len() {
-		if i1 < ns1.len() {
-			n1 := ns1.at(i1)
-			if _, any := nf(n1); any {
-				// try to match zero or more at i2,
-				// restarting at i2+1 if it fails
-				next1 = i1
-				next2 = i2 + 1
-				i1++
-				backupMatches()
-				continue
+	var match func(i1, i2 int) bool
+	match = func(i1, i2 int) bool {
+		if i1 == ns1.len() {
+			return i2 == ns2.len()
+		}
+		n1 := ns1.at(i1)
+		if ident, any := nf(n1); any {
+			wc := m.wildcard(ident)
+			var list []substitution
+			for end := i2; ; end++ {
+				if len(list) >= wc.min {
+					oldMatches := valsCopy(m.values)
+					if m.wildcardMatchList(ident, list) && match(i1+1, end) {
+						return true
+					}
+					m.values = oldMatches
+				}
+				if end == ns2.len() || len(list) == wc.max {
+					return false
+				}
+				list = append(list, ns2.substitution(end))
 			}
-			if i2 < ns2.len() && mf(m, n1, ns2.at(i2)) {
-				// ordinary match
-				i1++
-				i2++
-				continue
+		}
+		oldMatches := valsCopy(m.values)
+		if i2 < ns2.len() && mf(m, n1, ns2.at(i2)) && match(i1+1, i2+1) {
+			return true
+		}
+		m.values = oldMatches
+		return false
+	}
+	return match(0, 0)
+}
+
+// unorderedMatches matches two lists regardless of the order of the elements. Each non-any element of ns1 must match
+// a distinct element of ns2, while the remaining elements of ns2 are only allowed if there is any "any" wildcard in ns1.
+// Each any wildcard takes its minimum number of the remaining elements, then the rest are taken by the first ones as
+// many as they allow (e.g. the first "@*_" records all the rest, while the others record an empty list).
+// It backtracks to try other elements of ns2 if the matching of the following elements fails.
+func (m *Matcher) unorderedMatches(ns1, ns2 iterable, nf wildNameFunc, mf matchFunc) bool {
+	var (
+		elems     []interface{}
+		anyIdents []string
+	)
+	for i := 0; i < ns1.len(); i++ {
+		n1 := ns1.at(i)
+		if ident, isAny := nf(n1); isAny {
+			anyIdents = append(anyIdents, ident)
+			continue
+		}
+		elems = append(elems, n1)
+	}
+	any := len(anyIdents) != 0
+	if len(elems) > ns2.len() || (!any && len(elems) != ns2.len()) {
+		return false
+	}
+
+	used := make([]bool, ns2.len())
+	var match func(i int) bool
+	match = func(i int) bool {
+		if i == len(elems) {
+			var rest []substitution
+			for j := 0; j < ns2.len(); j++ {
+				if !used[j] {
+					rest = append(rest, ns2.substitution(j))
+				}
+			}
+			counts := make([]int, len(anyIdents))
+			left := len(rest)
+			for k, ident := range anyIdents {
+				counts[k] = m.wildcard(ident).min
+				left -= counts[k]
+			}
+			if left < 0 {
+				return false
+			}
+			for k, ident := range anyIdents {
+				wc := m.wildcard(ident)
+				if wc.max == -1 || wc.max-counts[k] >= left {
+					counts[k] += left
+					left = 0
+					break
+				}
+				left -= wc.max - counts[k]
+				counts[k] = wc.max
+			}
+			if left != 0 {
+				return false
 			}
+			oldMatches := valsCopy(m.values)
+			var offset int
+			for k, ident := range anyIdents {
+				if !m.wildcardMatchList(ident, rest[offset:offset+counts[k]]) {
+					m.values = oldMatches
+					return false
+				}
+				offset += counts[k]
+			}
+			return true
 		}
-		// mismatch, try to restart
-		if 0 < next2 && next2 <= ns2.len() {
-			i1 = next1
-			i2 = next2
+		for j := 0; j < ns2.len(); j++ {
+			if used[j] {
+				continue
+			}
+			oldMatches := valsCopy(m.values)
+			if mf(m, elems[i], ns2.at(j)) {
+				used[j] = true
+				if match(i + 1) {
+					return true
+				}
+				used[j] = false
+			}
 			m.values = oldMatches
-			continue
 		}
 		return false
 	}
-	return true
+	return match(0)
 }
 
 // Node comparisons
@@ -587,9 +1189,9 @@ func wildNameFromNode(in interface{}) (string, bool) {
 		if !ok {
 			return "", false
 		}
-		return fromWildName(name)
+		return name, isWildAnyName(name)
 	case *hclsyntax.Attribute:
-		return fromWildName(node.Name)
+		return node.Name, isWildAnyName(node.Name)
 	default:
 		return "", false
 	}
@@ -609,8 +1211,7 @@ func (m *Matcher) attribute(x *hclsyntax.Attribute, y hclsyntax.Node) bool {
 		switch y := y.(type) {
 		case *hclsyntax.Attribute,
 			*hclsyntax.Block:
-			name, _ := fromWildName(x.Name)
-			return m.wildcardMatchNode(name, y)
+			return m.wildcardMatchNode(x.Name, y)
 		default:
 			return false
 		}
@@ -624,8 +1225,8 @@ func (m *Matcher) block(x, y *hclsyntax.Block) bool {
 	if x == nil || y == nil {
 		return x == y
 	}
-	return m.potentialWildcardIdentEqual(x.Type, y.Type) &&
-		m.potentialWildcardIdentsEqual(x.Labels, y.Labels) &&
+	return m.label(x.Type, newLabelSubstitution(y.Type, y.TypeRange)) &&
+		m.iterableMatches(stringIterable(x.Labels), labelIterable{y.Labels, y.LabelRanges}, wildNameFromString, matchLabel) &&
 		m.body(x.Body, y.Body)
 }
 
@@ -635,24 +1236,249 @@ func (m *Matcher) body(x, y *hclsyntax.Body) bool {
 	}
 
 	// Sort the attributes/blocks to reserve the order in source
-	bodyEltsX := sortBody(x)
+	var bodyEltsX, deepEltsX, notEltsX []hclsyntax.Node
+	for _, elt := range sortBody(x) {
+		// The descendant elements don't match the elements of y, but any descendant of y.
+		if elem, ok := wrappedElement(elt, deepName); ok {
+			deepEltsX = append(deepEltsX, elem)
+			continue
+		}
+		// The negative elements don't match any element of y, which are checked after the others are matched, so that
+		// they can reference the wildcards recorded by the others.
+		if elem, ok := wrappedElement(elt, notName); ok {
+			notEltsX = append(notEltsX, elem)
+			continue
+		}
+		bodyEltsX = append(bodyEltsX, elt)
+	}
 	bodyEltsY := sortBody(y)
-	return m.iterableMatches(nodeIterable(bodyEltsX), nodeIterable(bodyEltsY), wildNameFromNode, matchNode)
+	if m.unordered {
+		if !m.unorderedMatches(nodeIterable(bodyEltsX), nodeIterable(bodyEltsY), wildNameFromNode, matchNode) {
+			return false
+		}
+	} else if !m.iterableMatches(nodeIterable(bodyEltsX), nodeIterable(bodyEltsY), wildNameFromNode, matchNode) {
+		return false
+	}
+	for _, elem := range deepEltsX {
+		if !m.deep(elem, y, false) {
+			return false
+		}
+	}
+	for _, elem := range notEltsX {
+		if m.present(elem, y, bodyEltsY) {
+			return false
+		}
+	}
+	return true
+}
+
+// present tells whether any element of the body matches the negative element, or any descendant of the body if it is
+// in the descendant form. The wildcard values are not recorded.
+func (m *Matcher) present(elem hclsyntax.Node, body *hclsyntax.Body, bodyElts []hclsyntax.Node) bool {
+	oldMatches := valsCopy(m.values)
+	defer func() { m.values = oldMatches }()
+	if deepElem, ok := wrappedElement(elem, deepName); ok {
+		return m.deep(deepElem, body, false)
+	}
+	for _, elt := range bodyElts {
+		if m.node(elem, elt) {
+			return true
+		}
+		m.values = valsCopy(oldMatches)
+	}
+	return false
+}
+
+// deep matches the pattern against the descendants of the node (and the node itself if self is true), the wildcard
+// values are recorded by the first match (DFS).
+func (m *Matcher) deep(pattern, node hclsyntax.Node, self bool) bool {
+	var found bool
+	// The node itself is the first visited one.
+	first := true
+	hclsyntax.VisitAll(node, func(n hclsyntax.Node) hcl.Diagnostics {
+		if found || (first && !self) {
+			first = false
+			return nil
+		}
+		first = false
+		oldMatches := valsCopy(m.values)
+		if m.node(pattern, n) {
+			found = true
+			return nil
+		}
+		m.values = oldMatches
+		return nil
+	})
+	return found
+}
+
+// wrappedElement returns the element wrapped by the block of the name (i.e. the descendant operator in body form, or
+// the negative element), if the node is such a wrapper.
+func wrappedElement(node hclsyntax.Node, name string) (hclsyntax.Node, bool) {
+	blk, ok := node.(*hclsyntax.Block)
+	if !ok || blk.Type != name || len(blk.Labels) != 0 {
+		return nil, false
+	}
+	elts := sortBody(blk.Body)
+	if len(elts) != 1 {
+		return nil, false
+	}
+	return elts[0], true
 }
 
 func (m *Matcher) exprs(exprs1, exprs2 []hclsyntax.Expression) bool {
 	return m.iterableMatches(exprIterable(exprs1), exprIterable(exprs2), wildNameFromNode, matchNode)
 }
 
+// Template comparisons
+
+// templateSegment is a segment of the parts of a template, which is either the literal text or an interpolated
+// expression (or a directive).
+type templateSegment struct {
+	text string
+	expr hclsyntax.Expression
+}
+
+// templateParts returns the parts of the template node.
+func templateParts(node hclsyntax.Node) ([]hclsyntax.Expression, bool) {
+	switch node := node.(type) {
+	case *hclsyntax.TemplateExpr:
+		return node.Parts, true
+	case *hclsyntax.TemplateWrapExpr:
+		return []hclsyntax.Expression{node.Wrapped}, true
+	default:
+		return nil, false
+	}
+}
+
+// templateSegments merges the adjacent literal parts of a template (e.g. the lines of a heredoc) into one segment.
+func templateSegments(parts []hclsyntax.Expression) []templateSegment {
+	var segs []templateSegment
+	for _, part := range parts {
+		if lit, ok := part.(*hclsyntax.LiteralValueExpr); ok && lit.Val.Type() == cty.String && lit.Val.IsKnown() && !lit.Val.IsNull() {
+			if n := len(segs); n != 0 && segs[n-1].expr == nil {
+				segs[n-1].text += lit.Val.AsString()
+				continue
+			}
+			segs = append(segs, templateSegment{text: lit.Val.AsString()})
+			continue
+		}
+		segs = append(segs, templateSegment{expr: part})
+	}
+	return segs
+}
+
+// textWildcard returns the wildcard ident and the wildcard, if the expression is a text wildcard.
+func (m *Matcher) textWildcard(expr hclsyntax.Expression) (string, wildcard, bool) {
+	ident, ok := variableExpr(expr)
+	if !ok || !isWildName(ident) {
+		return "", wildcard{}, false
+	}
+	wc := m.wildcard(ident)
+	return ident, wc, wc.text
+}
+
+func (m *Matcher) hasTextWildcard(parts []hclsyntax.Expression) bool {
+	for _, part := range parts {
+		if _, _, ok := m.textWildcard(part); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// templateParts matches the parts of the templates, where each text wildcard of the pattern matches a substring of the
+// literal text of the target. The shorter substrings are tried first.
+func (m *Matcher) templateParts(parts1, parts2 []hclsyntax.Expression) bool {
+	return m.templateSegments(templateSegments(parts1), templateSegments(parts2), 0)
+}
+
+// templateSegments matches the segments of the templates, where the first offset bytes of the literal text of segs2[0]
+// are already matched.
+func (m *Matcher) templateSegments(segs1, segs2 []templateSegment, offset int) bool {
+	if len(segs2) != 0 && segs2[0].expr == nil && offset == len(segs2[0].text) {
+		segs2, offset = segs2[1:], 0
+	}
+	if len(segs1) == 0 {
+		return len(segs2) == 0
+	}
+	seg := segs1[0]
+	if seg.expr == nil {
+		if len(segs2) == 0 || segs2[0].expr != nil || !strings.HasPrefix(segs2[0].text[offset:], seg.text) {
+			return false
+		}
+		return m.templateSegments(segs1[1:], segs2, offset+len(seg.text))
+	}
+	ident, wc, ok := m.textWildcard(seg.expr)
+	if !ok {
+		if len(segs2) == 0 || segs2[0].expr == nil || offset != 0 {
+			return false
+		}
+		return m.node(seg.expr, segs2[0].expr) && m.templateSegments(segs1[1:], segs2[1:], 0)
+	}
+	var text string
+	if len(segs2) != 0 && segs2[0].expr == nil {
+		text = segs2[0].text[offset:]
+	}
+	for end, n := 0, 0; ; n++ {
+		if n >= wc.min {
+			oldValues := valsCopy(m.values)
+			if m.wildcardMatchString(ident, text[:end]) && m.templateSegments(segs1[1:], segs2, offset+end) {
+				return true
+			}
+			m.values = oldValues
+		}
+		if end == len(text) || n == wc.max {
+			return false
+		}
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+}
+
 // Operation comparisons
 
-func (m *Matcher) operation(op1, op2 *hclsyntax.Operation) bool {
+// operation matches the operations, where the operation of the pattern can be the placeholder of an operator
+// wildcard. The rng is the source range of the operator of op2.
+func (m *Matcher) operation(op1, op2 *hclsyntax.Operation, rng hcl.Range) bool {
 	if op1 == nil || op2 == nil {
 		return op1 == op2
 	}
+	for _, wc := range m.wildcards {
+		if wc.op == op1 {
+			return m.wildcardMatchOperator(wc, newOperatorSubstitution(op2, rng))
+		}
+	}
 	return op1.Impl == op2.Impl && op1.Type.Equals(op2.Type)
 }
 
+// operatorRange returns the source range of the operator, which starts from the start.
+func operatorRange(op *hclsyntax.Operation, filename string, start hcl.Pos) hcl.Range {
+	n := len(opSymbols[op])
+	return hcl.Range{
+		Filename: filename,
+		Start:    start,
+		End:      hcl.Pos{Line: start.Line, Column: start.Column + n, Byte: start.Byte + n},
+	}
+}
+
+// skipSpaces returns the position after the spaces (if any) starting from the position in source.
+func (m *Matcher) skipSpaces(pos hcl.Pos) hcl.Pos {
+	for pos.Byte < len(m.b) {
+		switch m.b[pos.Byte] {
+		case ' ', '\t', '\r':
+			pos.Column++
+		case '\n':
+			pos.Line++
+			pos.Column = 1
+		default:
+			return pos
+		}
+		pos.Byte++
+	}
+	return pos
+}
+
 // ObjectConsItems comparisons
 
 func wildNameFromObjectConsItem(in interface{}) (string, bool) {
@@ -661,7 +1487,7 @@ func wildNameFromObjectConsItem(in interface{}) (string, bool) {
 		if !ok {
 			return "", false
 		}
-		return fromWildName(name)
+		return name, isWildAnyName(name)
 	}
 	return "", false
 }
@@ -682,34 +1508,175 @@ func (m *Matcher) objectConsItem(item1, item2 hclsyntax.ObjectConsItem) bool {
 }
 
 func (m *Matcher) objectConsItems(items1, items2 []hclsyntax.ObjectConsItem) bool {
+	if m.unordered {
+		return m.unorderedMatches(objectConsItemIterable(items1), objectConsItemIterable(items2), wildNameFromObjectConsItem, matchObjectConsItem)
+	}
 	return m.iterableMatches(objectConsItemIterable(items1), objectConsItemIterable(items2), wildNameFromObjectConsItem, matchObjectConsItem)
 }
 
 // String comparisons
 
 func wildNameFromString(in interface{}) (string, bool) {
-	return fromWildName(in.(string))
-}
-
-func matchString(m *Matcher, x, y interface{}) bool {
-	sx, sy := x.(string), y.(string)
-	return m.potentialWildcardIdentEqual(sx, sy)
+	name := in.(string)
+	return name, isWildAnyName(name)
 }
 
 func (m *Matcher) potentialWildcardIdentEqual(identX, identY string) bool {
 	if !isWildName(identX) {
+		if strings.Contains(identX, "${"+wildPrefix) {
+			return m.templateSegments(labelSegments(identX), []templateSegment{{text: identY}}, 0)
+		}
 		return identX == identY
 	}
-	name, _ := fromWildName(identX)
-	return m.wildcardMatchString(name, identY)
+	return m.wildcardMatchString(identX, identY)
 }
 
-func (m *Matcher) potentialWildcardIdentsEqual(identX, identY []string) bool {
-	return m.iterableMatches(stringIterable(identX), stringIterable(identY), wildNameFromString, matchString)
+// labelSegments splits the block label of the pattern into the segments of the literal text and the text wildcards,
+// which are kept as "${<wildcard ident>}" in the label, e.g. "prod-${hclgrep_x-0}".
+func labelSegments(label string) []templateSegment {
+	var segs []templateSegment
+	for {
+		start := strings.Index(label, "${"+wildPrefix)
+		if start == -1 {
+			break
+		}
+		end := strings.IndexByte(label[start:], '}')
+		if end == -1 {
+			break
+		}
+		end += start
+		if start != 0 {
+			segs = append(segs, templateSegment{text: label[:start]})
+		}
+		segs = append(segs, templateSegment{expr: &hclsyntax.ScopeTraversalExpr{
+			Traversal: hcl.Traversal{hcl.TraverseRoot{Name: label[start+2 : end]}},
+		}})
+		label = label[end+1:]
+	}
+	if label != "" {
+		segs = append(segs, templateSegment{text: label})
+	}
+	return segs
+}
+
+func matchLabel(m *Matcher, x, y interface{}) bool {
+	return m.label(x.(string), y.(substitution))
+}
+
+// label matches the block label (or block type) of the pattern against the one of the target.
+func (m *Matcher) label(pattern string, val substitution) bool {
+	if !isWildName(pattern) {
+		return m.potentialWildcardIdentEqual(pattern, *val.String)
+	}
+	return m.wildcardMatchStringSubstitution(pattern, val)
 }
 
 // Traversal comparisons
 
+// traversalStep is a step of a flattened traversal expression, which is either a static traverser (e.g. ".foo",
+// "[0]"), or the key of an index expression (e.g. "[var.foo]").
+type traversalStep struct {
+	traverser hcl.Traverser
+	key       hclsyntax.Expression
+}
+
+type traversalStepIterable []traversalStep
+
+func (it traversalStepIterable) at(i int) interface{} {
+	return it[i]
+}
+
+func (it traversalStepIterable) len() int {
+	return len(it)
+}
+
+func (it traversalStepIterable) substitution(i int) substitution {
+	if it[i].key != nil {
+		return newNodeSubstitution(it[i].key)
+	}
+	return newTraverserSubstitution(it[i].traverser)
+}
+
+// flattenTraversal flattens the traversal expression (i.e. ScopeTraversalExpr, RelativeTraversalExpr and IndexExpr)
+// into its source that is not a traversal expression (nil for a ScopeTraversalExpr) and the steps applied to it.
+func flattenTraversal(expr hclsyntax.Node) (hclsyntax.Node, []traversalStep) {
+	var (
+		source hclsyntax.Node
+		steps  []traversalStep
+		trav   hcl.Traversal
+	)
+	switch expr := expr.(type) {
+	case *hclsyntax.ScopeTraversalExpr:
+		trav = expr.Traversal
+	case *hclsyntax.RelativeTraversalExpr:
+		source, steps = flattenTraversal(expr.Source)
+		trav = expr.Traversal
+	case *hclsyntax.IndexExpr:
+		source, steps = flattenTraversal(expr.Collection)
+		return source, append(steps, traversalStep{key: expr.Key})
+	default:
+		return expr, nil
+	}
+	for _, t := range trav {
+		steps = append(steps, traversalStep{traverser: t})
+	}
+	return source, steps
+}
+
+// traversalExpr matches the traversal expressions by their flattened steps, so that the wildcards (including the any
+// wildcards) can be used at any step. E.g. "aws_instance.$x[$i].id" (an IndexExpr in the pattern) matches
+// "aws_instance.foo[0].id" (a ScopeTraversalExpr).
+//
+// A node that is not a traversal expression is taken as a source without any step, which can be matched by the any
+// wildcards, e.g. "f().$*_" matches "f()".
+func (m *Matcher) traversalExpr(x, y hclsyntax.Node) bool {
+	sourceX, stepsX := flattenTraversal(x)
+	sourceY, stepsY := flattenTraversal(y)
+	return m.node(sourceX, sourceY) &&
+		m.iterableMatches(traversalStepIterable(stepsX), traversalStepIterable(stepsY), wildNameFromTraversalStep, matchTraversalStep)
+}
+
+// wildNameFromTraversalStep returns the wildcard of a name step (e.g. "var.$*_"). The wildcard of an index key (e.g.
+// "foo[$*_]") always matches one index step.
+func wildNameFromTraversalStep(in interface{}) (string, bool) {
+	var name string
+	switch trav := in.(traversalStep).traverser.(type) {
+	case hcl.TraverseRoot:
+		name = trav.Name
+	case hcl.TraverseAttr:
+		name = trav.Name
+	default:
+		return "", false
+	}
+	return name, isWildAnyName(name)
+}
+
+func matchTraversalStep(m *Matcher, x, y interface{}) bool {
+	stepX, stepY := x.(traversalStep), y.(traversalStep)
+	if stepX.key == nil {
+		if stepY.key == nil {
+			return m.traverser(stepX.traverser, stepY.traverser)
+		}
+		// The static index of the pattern matches the dynamic index of a literal key.
+		idx, ok := stepX.traverser.(hcl.TraverseIndex)
+		if !ok {
+			return false
+		}
+		v, ok := literalValue(stepY.key)
+		return ok && idx.Key.RawEquals(v)
+	}
+	if name, ok := variableExpr(stepX.key); ok && isWildName(name) {
+		if stepY.key != nil {
+			return m.wildcardMatchNode(name, stepY.key)
+		}
+		if _, ok := stepY.traverser.(hcl.TraverseIndex); ok {
+			return m.wildcardMatchTraverse(name, stepY.traverser)
+		}
+		return false
+	}
+	return stepY.key != nil && m.node(stepX.key, stepY.key)
+}
+
 func (m *Matcher) traversal(traversal1, traversal2 hcl.Traversal) bool {
 	if len(traversal1) != len(traversal2) {
 		return false
@@ -743,7 +1710,95 @@ func (m *Matcher) traverser(t1, t2 hcl.Traverser) bool {
 
 // Wildcard matchers
 
-func (m *Matcher) wildcardMatchNode(name string, node hclsyntax.Node) bool {
+// wildcard returns the wildcard of the ident in the pattern, which is looked up in the wildcard table of the pattern.
+func (m *Matcher) wildcard(ident string) wildcard {
+	name, any := fromWildName(ident)
+	if i := strings.LastIndexByte(ident, '-'); i != -1 {
+		if idx, err := strconv.Atoi(ident[i+1:]); err == nil && idx < len(m.wildcards) {
+			return m.wildcards[idx]
+		}
+	}
+	// The pattern is not compiled with a wildcard table.
+	return wildcard{name: name, any: any, max: -1}
+}
+
+// satisfies tells whether the value satisfies the constraints of the wildcard.
+func (m *Matcher) satisfies(wc wildcard, val substitution) bool {
+	if wc.kind != nil && !wc.kind(val) {
+		return false
+	}
+	if wc.alts != nil && !m.alternatives(wc.alts, val) {
+		return false
+	}
+	if wc.rx == nil {
+		return true
+	}
+	lit, ok := substitutionLiteral(val)
+	return ok && wc.rx.MatchString(lit)
+}
+
+// alternatives tells whether any of the alternatives matches the value, where the wildcards inside the first matched
+// alternative are recorded.
+func (m *Matcher) alternatives(alts []hclsyntax.Node, val substitution) bool {
+	for _, alt := range alts {
+		oldMatches := valsCopy(m.values)
+		if m.alternative(alt, val) {
+			return true
+		}
+		m.values = oldMatches
+	}
+	return false
+}
+
+func (m *Matcher) alternative(alt hclsyntax.Node, val substitution) bool {
+	switch {
+	case val.Node != nil:
+		return m.node(alt, val.Node)
+	case val.String != nil:
+		return m.alternativeString(alt, *val.String)
+	case val.ObjectConsItem != nil:
+		attr, ok := alt.(*hclsyntax.Attribute)
+		if !ok {
+			return false
+		}
+		item := hclsyntax.ObjectConsItem{
+			KeyExpr: &hclsyntax.ObjectConsKeyExpr{
+				Wrapped: &hclsyntax.ScopeTraversalExpr{Traversal: hcl.Traversal{hcl.TraverseRoot{Name: attr.Name}}},
+			},
+			ValueExpr: attr.Expr,
+		}
+		return m.objectConsItem(item, *val.ObjectConsItem)
+	case val.Traverser != nil:
+		switch trav := (*val.Traverser).(type) {
+		case hcl.TraverseRoot:
+			return m.alternativeString(alt, trav.Name)
+		case hcl.TraverseAttr:
+			return m.alternativeString(alt, trav.Name)
+		case hcl.TraverseIndex:
+			if name, ok := variableExpr(alt); ok && isWildName(name) {
+				return m.wildcardMatchTraverse(name, trav)
+			}
+			v, ok := literalValue(alt)
+			return ok && trav.Key.RawEquals(v)
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+}
+
+// alternativeString matches the alternative against a string, which is either a name (e.g. a block type) or a
+// quoted string (e.g. a block label).
+func (m *Matcher) alternativeString(alt hclsyntax.Node, s string) bool {
+	if name, ok := variableExpr(alt); ok {
+		return m.potentialWildcardIdentEqual(name, s)
+	}
+	v, ok := literalValue(alt)
+	return ok && v.Type() == cty.String && v.AsString() == s
+}
+
+func (m *Matcher) wildcardMatchNode(ident string, node hclsyntax.Node) bool {
 	// Wildcard never matches multiple attributes/blocks.
 	// On one hand, it is because we have any wildcard, which already meets this requirement.
 	// One the other hand, Go panics to use the attributes/blocks slice as map key.
@@ -753,6 +1808,11 @@ func (m *Matcher) wildcardMatchNode(name string, node hclsyntax.Node) bool {
 		return false
 	}
 
+	wc := m.wildcard(ident)
+	if !m.satisfies(wc, newNodeSubstitution(node)) {
+		return false
+	}
+	name := wc.name
 	if name == "_" {
 		// values are discarded, matches anything
 		return true
@@ -764,25 +1824,48 @@ func (m *Matcher) wildcardMatchNode(name string, node hclsyntax.Node) bool {
 	}
 	switch {
 	case prev.String != nil:
+		if v, ok := literalValue(node); ok {
+			// e.g. x = "foo" after the text wildcard "$x-suffix"
+			return v.Type() == cty.String && v.AsString() == *prev.String
+		}
 		nodeVar, ok := variableExpr(node)
 		return ok && nodeVar == *prev.String
 	case prev.Node != nil:
 		return m.node(prev.Node, node)
-	case prev.ObjectConsItem != nil:
+	case prev.Traverser != nil:
+		idx, ok := (*prev.Traverser).(hcl.TraverseIndex)
+		if !ok {
+			return false
+		}
+		v, ok := literalValue(node)
+		return ok && idx.Key.RawEquals(v)
+	case prev.ObjectConsItem != nil, prev.List != nil, prev.Operator != nil:
 		return false
 	default:
 		panic("never reach here")
 	}
 }
 
-func (m *Matcher) wildcardMatchString(name, target string) bool {
+func (m *Matcher) wildcardMatchString(ident, target string) bool {
+	return m.wildcardMatchStringSubstitution(ident, newStringSubstitution(target))
+}
+
+// wildcardMatchStringSubstitution is wildcardMatchString for the string substitution, which records the substitution
+// as is (e.g. together with the range of the block label).
+func (m *Matcher) wildcardMatchStringSubstitution(ident string, val substitution) bool {
+	target := *val.String
+	wc := m.wildcard(ident)
+	if !m.satisfies(wc, val) {
+		return false
+	}
+	name := wc.name
 	if name == "_" {
 		// values are discarded, matches anything
 		return true
 	}
 	prev, ok := m.values[name]
 	if !ok {
-		m.values[name] = newStringSubstitution(target)
+		m.values[name] = val
 		return true
 	}
 
@@ -790,6 +1873,10 @@ func (m *Matcher) wildcardMatchString(name, target string) bool {
 	case prev.String != nil:
 		return *prev.String == target
 	case prev.Node != nil:
+		if v, ok := literalValue(prev.Node); ok {
+			// e.g. the text wildcard "$x-suffix" after x = "foo"
+			return v.Type() == cty.String && v.AsString() == target
+		}
 		prevName, ok := variableExpr(prev.Node)
 		return ok && prevName == target
 	case prev.ObjectConsItem != nil:
@@ -803,12 +1890,19 @@ func (m *Matcher) wildcardMatchString(name, target string) bool {
 		default:
 			return false
 		}
+	case prev.List != nil, prev.Operator != nil:
+		return false
 	default:
 		panic("never reach here")
 	}
 }
 
-func (m *Matcher) wildcardMatchObjectConsItem(name string, item hclsyntax.ObjectConsItem) bool {
+func (m *Matcher) wildcardMatchObjectConsItem(ident string, item hclsyntax.ObjectConsItem) bool {
+	wc := m.wildcard(ident)
+	if !m.satisfies(wc, newObjectConsItemSubstitution(&item)) {
+		return false
+	}
+	name := wc.name
 	if name == "_" {
 		// values are discarded, matches anything
 		return true
@@ -825,14 +1919,19 @@ func (m *Matcher) wildcardMatchObjectConsItem(name string, item hclsyntax.Object
 		return false
 	case prev.ObjectConsItem != nil:
 		return m.objectConsItem(*prev.ObjectConsItem, item)
-	case prev.Traverser != nil:
+	case prev.Traverser != nil, prev.List != nil, prev.Operator != nil:
 		return false
 	default:
 		panic("never reach here")
 	}
 }
 
-func (m *Matcher) wildcardMatchTraverse(name string, trav hcl.Traverser) bool {
+func (m *Matcher) wildcardMatchTraverse(ident string, trav hcl.Traverser) bool {
+	wc := m.wildcard(ident)
+	if !m.satisfies(wc, newTraverserSubstitution(trav)) {
+		return false
+	}
+	name := wc.name
 	if name == "_" {
 		// values are discarded, matches anything
 		return true
@@ -853,28 +1952,118 @@ func (m *Matcher) wildcardMatchTraverse(name string, trav hcl.Traverser) bool {
 			return false
 		}
 	case prev.Node != nil:
-		return false
+		idx, ok := trav.(hcl.TraverseIndex)
+		if !ok {
+			return false
+		}
+		v, ok := literalValue(prev.Node)
+		return ok && idx.Key.RawEquals(v)
 	case prev.ObjectConsItem != nil:
 		return false
 	case prev.Traverser != nil:
 		return m.traverser(trav, *prev.Traverser)
+	case prev.List != nil, prev.Operator != nil:
+		return false
 	default:
 		panic("never reach here")
 	}
 }
 
+// wildcardMatchOperator matches the operator wildcard against the operator. A named operator wildcard records the
+// operator, and the other occurrences of the name must match the same operator.
+func (m *Matcher) wildcardMatchOperator(wc wildcard, val substitution) bool {
+	if !m.satisfies(wc, val) {
+		return false
+	}
+	if wc.name == "_" {
+		// values are discarded, matches anything
+		return true
+	}
+	prev, ok := m.values[wc.name]
+	if !ok {
+		m.values[wc.name] = val
+		return true
+	}
+	return prev.Operator != nil && prev.Operator.op == val.Operator.op
+}
+
+// wildcardMatchList matches the any wildcard against the list of elements, each of which must satisfy the constraints
+// of the wildcard. A named any wildcard records the list, and the other occurrences of the name must match an equal
+// list.
+func (m *Matcher) wildcardMatchList(ident string, list []substitution) bool {
+	wc := m.wildcard(ident)
+	for _, elem := range list {
+		if !m.satisfies(wc, elem) {
+			return false
+		}
+	}
+	name := wc.name
+	if name == "_" {
+		// values are discarded, matches anything
+		return true
+	}
+	prev, ok := m.values[name]
+	if !ok {
+		m.values[name] = newListSubstitution(list)
+		return true
+	}
+	if prev.List == nil || len(prev.List) != len(list) {
+		return false
+	}
+	for i, elem := range list {
+		if !m.substitutionEqual(prev.List[i], elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// substitutionEqual tells whether the two substitutions are equal, e.g. the elements of the lists recorded by the any
+// wildcards, or the values of a wildcard in the intersected submatches.
+func (m *Matcher) substitutionEqual(x, y substitution) bool {
+	switch {
+	case x.String != nil:
+		return y.String != nil && *x.String == *y.String
+	case x.Node != nil:
+		return y.Node != nil && m.node(x.Node, y.Node)
+	case x.ObjectConsItem != nil:
+		return y.ObjectConsItem != nil && m.objectConsItem(*x.ObjectConsItem, *y.ObjectConsItem)
+	case x.Traverser != nil:
+		return y.Traverser != nil && m.traverser(*x.Traverser, *y.Traverser)
+	case x.List != nil:
+		if y.List == nil || len(x.List) != len(y.List) {
+			return false
+		}
+		for i := range x.List {
+			if !m.substitutionEqual(x.List[i], y.List[i]) {
+				return false
+			}
+		}
+		return true
+	case x.Operator != nil:
+		return y.Operator != nil && x.Operator.op == y.Operator.op
+	default:
+		return false
+	}
+}
+
 // Two wildcard: expression wildcard ($) and attribute wildcard (@)
-// - expression wildcard: $<ident> => hclgrep_<ident>
-// - expression wildcard (any): $<ident> => hclgrep_any_<ident>
-// - attribute wildcard : @<ident> => hclgrep-<index>_<ident> = hclgrepattr
-// - attribute wildcard (any) : @<ident> => hclgrep_any-<index>_<ident> = hclgrepattr
+// - expression wildcard: $<ident> => hclgrep_<ident>-<index>
+// - expression wildcard (any): $<ident> => hclgrep_any_<ident>-<index>
+// - attribute wildcard : @<ident> => hclgrep_<ident>-<index> = hclgrepattr
+// - attribute wildcard (any) : @<ident> => hclgrep_any_<ident>-<index> = hclgrepattr
 const (
 	wildPrefix    = "hclgrep_"
 	wildExtraAny  = "any_"
 	wildAttrValue = "hclgrepattr"
-)
 
-var wildattrCounters = map[string]int{}
+	// deepName is the name of the block (function) that the descendant operator ("...") in body (expression) form is
+	// turned to.
+	deepName = "hclgrepdeep"
+
+	// notName is the name of the block that the negative element ("!") of a body is turned to.
+	notName = "hclgrepnot"
+)
 
 func wildName(name string, any bool) string {
 	prefix := wildPrefix
@@ -884,16 +2073,28 @@ func wildName(name string, any bool) string {
 	return prefix + name
 }
 
-func wildAttr(name string, any bool) string {
-	attr := wildName(name, any) + "-" + strconv.Itoa(wildattrCounters[name]) + "=" + wildAttrValue
-	wildattrCounters[name] += 1
-	return attr
+// wildIdent returns the ident for the wildcard, suffixed by its index in the wildcard table of the pattern. The index
+// also distinguishes the attribute wildcards with the same name in one body, as the attribute names in a body must be
+// unique.
+func wildIdent(name string, any bool, index int) string {
+	return wildName(name, any) + "-" + strconv.Itoa(index)
+}
+
+// wildAttr returns the attribute for the attribute wildcard.
+func wildAttr(name string, any bool, index int) string {
+	return wildIdent(name, any, index) + "=" + wildAttrValue
 }
 
 func isWildName(name string) bool {
 	return strings.HasPrefix(name, wildPrefix)
 }
 
+// isWildAnyName tells whether the name is of an any wildcard.
+func isWildAnyName(name string) bool {
+	_, any := fromWildName(name)
+	return isWildName(name) && any
+}
+
 func isWildAttr(key string, value hclsyntax.Expression) bool {
 	v, ok := variableExpr(value)
 	return ok && v == wildAttrValue && isWildName(key)
@@ -2,8 +2,13 @@ package hclgrep
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/hcl/v2"
@@ -333,6 +338,19 @@ EOF
 		{[]string{"-x", "a.$x.$_.$x"}, "a.x.y.x", 1},
 		{[]string{"-x", "$_.$x.$_.$x"}, "a.x.y.x", 1},
 		{[]string{"-x", "a.$x.$*_.$x"}, "a.x.y.z", 0},
+		{[]string{"-x", "a.$x.$*_.$x"}, "a.x.y.z.x", 1},
+		{[]string{"-x", "var.$*_"}, "a = var\nb = var.foo\nc = var.foo.bar[0]\nd = local.foo", 3},
+		{[]string{"-x", "var.$*_.id"}, "a = var.id\nb = var.foo[count.index].id\nc = var.foo.name", 2},
+		{[]string{"-x", "[var.$*p, local.$*p]"}, "a = [var.a.b, local.a.b]\nb = [var.a.b, local.a.c]\nc = [var.a[0], local.a[0]]", 2},
+		{[]string{"-x", "module.$m.$out"}, "a = module.vpc.subnet_ids\nb = module.vpc", 1},
+		{[]string{"-x", "data.$_.$_.$*_"}, "a = data.aws_ami.ubuntu.id\nb = data.aws_ami", "data.aws_ami.ubuntu.id"},
+		{[]string{"-x", "aws_instance.$x[$i].id"}, "a = aws_instance.web[0].id\nb = aws_instance.db[count.index].id\nc = aws_instance.web.id", 2},
+		{[]string{"-x", "a[$i].b[$i]"}, "x = a[0].b[0]\ny = a[0].b[1]\nz = a[k].b[k]", 2},
+		{[]string{"-x", "a[$i] + $i"}, "x = a[0] + 0\ny = a[0] + 1", "a[0] + 0"},
+		{[]string{"-x", "a[count.index]"}, "x = a[count.index]\ny = a[0]", 1},
+		{[]string{"-x", "a[$*_]"}, "x = a[0]", 1},
+		{[]string{"-x", "$_[*].$attr"}, "x = var.list[*].name\ny = var.list[*]", 1},
+		{[]string{"-x", "var.$x[*].$*_"}, "x = var.list[*].name.first\ny = var.list[*]", 2},
 
 		// relative traversal expression
 		{[]string{"-x", "sort()[0]"}, "sort()[0]", 1},
@@ -344,6 +362,8 @@ EOF
 		{[]string{"-x", "$_()[0]"}, "sort()[0]", 1},
 		{[]string{"-x", "$_()[0]"}, "sort(arg)[0]", 0},
 		{[]string{"-x", "$*_()[0]"}, "sort(arg)[0]", 0},
+		{[]string{"-x", "$_()[$i].$*_"}, "a = sort()[0]\nb = sort()[count.index].name", 3},
+		{[]string{"-x", "sort().$*_"}, "a = sort()", 1},
 
 		// TODO: object cons key expression
 		// TODO: template join expression
@@ -811,7 +831,7 @@ blk {
 		{[]string{"-x", "a = "}, "", parseErr(":1,3-3: Missing expression; Expected the start of an expression, but found the end of the file.")},
 
 		// no command
-		{[]string{}, "", otherErr("need at least one command")},
+		{[]string{}, "", otherErr("need at least one command or rule file")},
 
 		// empty source
 		{[]string{"-x", ""}, "", 1},
@@ -1004,6 +1024,254 @@ blk {
 }`,
 			want: 0,
 		},
+
+		// wildcard constraints
+		{[]string{"-x", `port = $x~"22|\\*"`}, "port = \"22\"", 1},
+		{[]string{"-x", `port = $x~"22|\\*"`}, "port = \"*\"", 1},
+		{[]string{"-x", `port = $x~"^(22|\\*)$"`}, "port = \"443\"", 0},
+		// the regexp must match the whole literal, the same as -rx
+		{[]string{"-x", `port = $x~"22|\\*"`}, "a {\nport = \"2222\"\n}\nb {\nport = \"**\"\n}", 0},
+		{[]string{"-x", `port = $x`, "-rx", `x="22|\\*"`}, "a {\nport = \"2222\"\n}\nb {\nport = \"**\"\n}", 0},
+		{[]string{"-x", `var.$x~"f"`}, "a = var.foo\nb = var.f", "var.f"},
+		{[]string{"-x", `resource $type~"azurerm_.*" $_ {@*_}`}, "resource \"azurerm_foo\" \"a\" {}\nresource \"aws_foo\" \"b\" {}", "resource \"azurerm_foo\" \"a\" {}"},
+		{[]string{"-x", `$_~"^var$".$x`}, "a = var.foo\nb = local.bar", "var.foo"},
+		{[]string{"-x", `var.$x~"f.*"`}, "a = var.foo\nb = var.bar", "var.foo"},
+		{[]string{"-x", `blk {@x~"b.*"}`}, "blk {\na = 1\n}\nblk {\nb = 2\n}", "blk {\nb = 2\n}"},
+		{[]string{"-x", `{@x~"a.*"}`}, "x = {a = 1}", 0},
+		{[]string{"-x", `f($x~"^1$", $x)`}, "a = f(1, 1)\nb = f(2, 2)", "f(1, 1)"},
+		// constraint in "-v"
+		{[]string{"-x", "blk {@*_}", "-v", `a = $_~"^1$"`}, "blk {\na = 1\n}\nblk {\na = 2\n}", "blk {\na = 2\n}"},
+		{[]string{"-x", `a = [$*x~"1"]`}, "a = [1, 1]\nb = [1, 2]", "a = [1, 1]"},
+		{[]string{"-x", `a = $x~1`}, "", tokErr(`:1,8-9: "~" must be followed by a quoted string, got TokenNumberLit`)},
+		{[]string{"-x", `a = $x~"("`}, "", tokErr(`:1,7-11: error parsing regexp: missing closing ): ` + "`(`")},
+		{[]string{"-x", `a = ~1`}, "", tokErr(`:1,5-6: "~" must follow a wildcard`)},
+		// wildcard kinds
+		{[]string{"-x", `a = $x:string`}, "a = \"foo\"", 1},
+		{[]string{"-x", `a = $x:string`}, "a = \"${foo}\"", 0},
+		{[]string{"-x", `a = $x:template`}, "a = \"${foo}\"", 1},
+		{[]string{"-x", `a = $x:number`}, "a = 1", 1},
+		{[]string{"-x", `a = $x:number`}, "a = \"1\"", 0},
+		{[]string{"-x", `a = $x:bool`}, "a = true", 1},
+		{[]string{"-x", `a = $x:null`}, "a = null", 1},
+		{[]string{"-x", `a = $x:bool`}, "a = null", 0},
+		{[]string{"-x", `a = $x:traversal`}, "a = var.foo[0]", 1},
+		{[]string{"-x", `a = $x:call`}, "a = f(1)\nb = 1", 1},
+		{[]string{"-x", `$x:call`}, "a = f(g(1))", 2},
+		{[]string{"-x", `a = $x:tuple`}, "a = [1]", 1},
+		{[]string{"-x", `a = $x:object`}, "a = {b = 1}", 1},
+		{[]string{"-x", `a = $x:for`}, "a = [for x in y: x]", 1},
+		{[]string{"-x", `a = $x:conditional`}, "a = x ? 1 : 2", 1},
+		{[]string{"-x", `a = $x:index`}, "a = x[y]", 1},
+		{[]string{"-x", `a = $x:splat`}, "a = x[*].y", 1},
+		{[]string{"-x", `a = $x:operation`}, "a = 1 + 2", 1},
+		{[]string{"-x", `a = $x:operation`}, "a = !c", 1},
+		{[]string{"-x", `blk {@x:block}`}, "blk {\na = 1\n}\nblk {\nb {}\n}", "blk {\nb {}\n}"},
+		{[]string{"-x", `blk {@x:attribute}`}, "blk {\na = 1\n}\nblk {\nb {}\n}", "blk {\na = 1\n}"},
+		{[]string{"-x", `{@x:attribute}`}, "a = {b = 1}", 1},
+		{[]string{"-x", `resource $x:string $_ {@*_}`}, "resource foo bar {}", 1},
+		{[]string{"-x", `a = $x:string~"f.*"`}, "a = \"foo\"\nb {\na = \"bar\"\n}", "a = \"foo\""},
+		{[]string{"-x", `a = x ? $x : $y`}, "a = x ? 1 : 2", 1},
+		// a name other than the kinds is not a kind
+		{[]string{"-x", `$c ? $a:b`}, "x = c ? a : b\ny = c ? b : a", "c ? a : b"},
+		{[]string{"-x", `a = $x:strng`}, "", parseErr(`:1,15-16: Missing newline after argument; An argument definition must end with a newline.`)},
+		{[]string{"-x", `blk {@x:string}`}, "", tokErr(`:1,9-15: unknown kind "string", must be one of ["attribute" "block"]`)},
+		{[]string{"-x", `[$*x:string]`}, "a = [\"x\", 1]\nb = [\"x\", \"y\"]\nc = []", 2},
+		// recorded any wildcard
+		{[]string{"-x", `[$*x, $*x]`}, "a = [1, 2, 1, 2]\nb = [1, 2, 2, 1]", "[1, 2, 1, 2]"},
+		{[]string{"-x", `[$*x, $*x]`}, "a = []", 1},
+		{[]string{"-x", `[$*x, 0, $*x]`}, "a = [1, 0, 1]\nb = [1, 0, 2]", "[1, 0, 1]"},
+		{[]string{"-x", `f($*x) + g($*x)`}, "a = f(1, 2) + g(1, 2)\nb = f(1) + g(2)", "f(1, 2) + g(1, 2)"},
+		{[]string{"-x", `blk $*x {}`, "-g", `blk $*x {}`}, "blk a b {}", 1},
+		{[]string{"-x", `a = [$*x, $x]`}, "a = [1, 1]", 0},
+		{[]string{"-x", `a = [$*_, $*_]`}, "a = [1, 2]", 1},
+		// any wildcards are matched lazily
+		{[]string{"-x", `[$*x, 2, $*y]`, "-rx", `x="1"`}, "a = [1, 2, 2]", 1},
+		{[]string{"-x", `[$*x, 2, $*y]`, "-rx", `x="1,2"`}, "a = [1, 2, 2]", 0},
+		// alternation
+		{[]string{"-x", `a = (1 | "1")`}, "a = 1", 1},
+		{[]string{"-x", `a = (1 | "1")`}, "a = \"1\"", 1},
+		{[]string{"-x", `a = (1 | "1")`}, "a = 2", 0},
+		{[]string{"-x", `(resource | data) $_ $_ {@*_}`}, "resource a b {}\ndata c d {}\nmodule e {}", 2},
+		{[]string{"-x", `resource ("a" | "x") $_ {@*_}`}, "resource a b {}\nresource c d {}", "resource a b {}"},
+		{[]string{"-x", `var.(foo | bar)`}, "x = [var.foo, var.bar, var.baz]", 2},
+		{[]string{"-x", `var.foo[(0 | "k")]`}, "x = [var.foo[0], var.foo[\"k\"], var.foo[1]]", 2},
+		{[]string{"-x", `f(a | b)`}, "x = [f(a), f(b), f(c)]", 2},
+		{[]string{"-x", `f((a | b), $x)`}, "x = [f(a, 1), f(b, 2)]", 2},
+		{[]string{"-x", `[for x in (a | b): x]`}, "x = [for x in a: x]", 1},
+		{[]string{"-x", `(f($x) | g($x)) + $x`}, "x = f(1) + 1\ny = g(2) + 2\nz = f(1) + 2", 2},
+		{[]string{"-x", `((a | b) | (c | d))`}, "x = [a, d, e]", 2},
+		{[]string{"-x", "blk {\n(a = 1 | b {})\n}"}, "blk {\na = 1\n}\nblk {\nb {}\n}\nblk {\na = 2\n}", 2},
+		{[]string{"-x", "blk {\n@*_\n... (a = 1 | b {})\n}"}, "blk {\nc {\nb {}\n}\n}", 1},
+		{[]string{"-x", `a = ...(f(1) | g(1))`}, "a = h(g(1))", 1},
+		{[]string{"-x", "{(a = 1 | b = 2)}"}, "x = {a = 1}\ny = {b = 2}\nz = {b = 1}", 2},
+		{[]string{"-x", "a = (1 | $x)", "-g", "a = 2"}, "a = 2", 1},
+		{[]string{"-x", "a = (1 |)"}, "", tokErr(`:1,8-9: empty alternative`)},
+		{[]string{"-x", "a = (1 | b = 2)"}, "", tokErr(`:1,5-16: alternatives must be all expressions or all attributes/blocks`)},
+		{[]string{"-x", "a = (1 | 1 +)"}, "", tokErr(`:1,10-13: cannot parse alternative: :1,1-2: Argument or block definition required; An argument or block definition is required here.`)},
+		// descendant operator
+		{
+			args: []string{"-x", `resource $_ $_ {
+  @*_
+  ... network_rules { default_action = "Allow" }
+}`},
+			src: `resource "azurerm_storage_account" "a" {
+  name = "a"
+  network_rules {
+    default_action = "Allow"
+  }
+}
+resource "azurerm_storage_account" "b" {
+  name = "b"
+  network_rules {
+    default_action = "Deny"
+  }
+}
+resource "azurerm_storage_account" "c" {
+  dynamic "rules" {
+    content {
+      network_rules {
+        default_action = "Allow"
+      }
+    }
+  }
+}`,
+			want: 2,
+		},
+		// the descendant elements don't consume the elements of the body
+		{[]string{"-x", "blk {\n... a = 1\n}"}, "blk {\nnest {\na = 1\n}\n}", 0},
+		{[]string{"-x", "blk {\n... a = 1\nnest {@*_}\n}"}, "blk {\nnest {\na = 1\n}\n}", 1},
+		// the descendants don't include the block itself
+		{[]string{"-x", "blk {\n@*_\n... blk {@*_}\n}"}, "blk {\na = 1\n}", 0},
+		// bindings, which are recorded by the first match (DFS)
+		{[]string{"-x", "blk {\nname = $x\n@*_\n... ref = $x\n}"}, "blk {\nname = 1\nnest {\nref = 2\n}\nnest {\nref = 1\n}\n}", 1},
+		{[]string{"-x", "blk {\nname = $x\n@*_\n... ref = $x\n}"}, "blk {\nname = 1\nnest {\nref = 2\n}\n}", 0},
+		{[]string{"-x", "blk {\n@*_\n... ref = $x\n}", "-x", "$x"}, "blk {\nnest {\nref = 2\n}\nref = 1\n}", "1"},
+		// nested descendant operators
+		{[]string{"-x", "blk {\n@*_\n... nest {\n@*_\n... a = 1\n}\n}"}, "blk {\nx {\nnest {\ny {\na = 1\n}\n}\n}\n}", 1},
+		// expression form
+		{[]string{"-x", "tags = ...(var.$x)", "-x", "var.$x"}, "tags = merge(local.tags, { env = var.env })", "var.env"},
+		{[]string{"-x", "...(var.x)"}, "a = var.x", 2},
+		{[]string{"-x", "f(a...)"}, "x = f(a...)", 1},
+		{[]string{"-x", "blk {\n... 1\n}"}, "", tokErr(`:2,5-6: "..." must be followed by an attribute or a block, got TokenNumberLit`)},
+		// -unordered
+		{[]string{"-x", "blk {\nb = 2\na = 1\n}"}, "blk {\na = 1\nb = 2\n}", 0},
+		{[]string{"-unordered", "-x", "blk {\nb = 2\na = 1\n}"}, "blk {\na = 1\nb = 2\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\nb = 2\n}"}, "blk {\na = 1\nb = 2\n}", 0},
+		{[]string{"-unordered", "-x", "blk {\nb = 2\n@*_\n}"}, "blk {\na = 1\nb = 2\nc = 3\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\nb = 2\nd = 4\n@*_\n}"}, "blk {\na = 1\nb = 2\nc = 3\n}", 0},
+		{[]string{"-unordered", "-x", "{b = 2, a = $x}"}, "x = {a = 1, b = 2}", "{a = 1, b = 2}"},
+		{[]string{"-unordered", "-x", "[b, a]"}, "x = [a, b]", 0},
+		// backtracking of the wildcards
+		{[]string{"-unordered", "-x", "blk {\n@x\nb = $y\nc = $y\n}"}, "blk {\nc = 1\nb = 1\na = 2\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\na = $x\n@*_\nnest {\nb = $x\n}\n}"}, "blk {\nnest {\nb = 2\n}\na = 1\nnest {\nb = 1\n}\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\na = $x\nnest {\nb = $x\n}\n}"}, "blk {\nnest {\nb = 2\n}\na = 1\n}", 0},
+		// quantified wildcards
+		{[]string{"-x", "[$+_]"}, "a = []\nb = [1]\nc = [1, 2]", 2},
+		{[]string{"-x", "[$?_]"}, "a = []\nb = [1]\nc = [1, 2]", 2},
+		{[]string{"-x", "[${2,}_]"}, "a = [1]\nb = [1, 2]\nc = [1, 2, 3]", 2},
+		{[]string{"-x", "[${2}_]"}, "a = [1]\nb = [1, 2]\nc = [1, 2, 3]", "[1, 2]"},
+		{[]string{"-x", "[${1,2}_]"}, "a = []\nb = [1, 2]\nc = [1, 2, 3]", "[1, 2]"},
+		{[]string{"-x", "[${1,2}x, $+y]", "-rx", `y="2,3,4"`}, "a = [1, 2, 3, 4]", 1},
+		{[]string{"-x", "[${2}x, $+y]", "-rx", `y="3,4"`}, "a = [1, 2, 3, 4]", 1},
+		{[]string{"-x", "[$?x, $+y, 3]", "-rx", `y="1,2"`}, "a = [1, 2, 3]", 1},
+		{[]string{"-x", "f($?x, 1)", "-x", "$x"}, "a = f(1)", 0},
+		{[]string{"-x", "blk {\n@?_\na = 1\n}"}, "blk {\na = 1\n}\nblk {\nb = 2\na = 1\n}\nblk {\nb = 2\nc = 3\na = 1\n}", 2},
+		{[]string{"-x", "{${1,}_: 1}"}, "a = {}\nb = {x = 1}", "{x = 1}"},
+		{[]string{"-x", "var.$+_"}, "a = var\nb = var.x", "var.x"},
+		{[]string{"-x", "blk ${2}x {@*_}", "-rx", `x="a,b"`}, "blk a {}\nblk a b {}", "blk a b {}"},
+		{[]string{"-unordered", "-x", "blk {\nip_rule {@*_}\n@*_:attribute\n}"}, "blk {\nip_rule {}\n}\nblk {\nip_rule {}\na = 1\n}\nblk {\nip_rule {}\nip_rule {}\n}", 2},
+		{[]string{"-unordered", "-x", "blk {\na = 1\n@{1,2}x\n@+y\n}"}, "blk {\na = 1\nb = 2\n}", 0},
+		{[]string{"-unordered", "-x", "blk {\na = 1\n@{1,2}x\n@+y\n}"}, "blk {\na = 1\nb = 2\nc = 3\nd = 4\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\na = 1\n@{1,2}x\n@+y\n}"}, "blk {\na = 1\nb = 2\nc = 3\nd = 4\ne = 5\n}", 1},
+		{[]string{"-unordered", "-x", "blk {\na = 1\n@{1,2}x\n@?y\n}"}, "blk {\na = 1\nb = 2\nc = 3\nd = 4\ne = 5\n}", 0},
+		{[]string{"-unordered", "-x", "blk {\n@+x\n@+x\n}"}, "blk {\na = 1\nb = 2\n}", 0},
+		{[]string{"-unordered", "-x", "blk {\n@+x\n@+x\n}"}, "blk {\nnest {}\nnest {}\n}", 1},
+		{[]string{"-x", "[${x}_]"}, "", tokErr(`:1,3-5: invalid quantifier, must be one of "{n}", "{n,}" and "{n,m}"`)},
+		{[]string{"-x", "[${1,2_]"}, "", tokErr(`:1,3-8: invalid quantifier, must be one of "{n}", "{n,}" and "{n,m}"`)},
+		{[]string{"-x", "[${2,1}_]"}, "", tokErr(`:1,3-8: invalid quantifier, the maximum must be positive and no less than the minimum`)},
+		{[]string{"-x", "[${0}_]"}, "", tokErr(`:1,3-6: invalid quantifier, the maximum must be positive and no less than the minimum`)},
+		// negative elements
+		{[]string{"-x", "blk {\n@*_\n!a = $_\n}"}, "blk {\na = 1\n}\nblk {\nb = 1\n}", "blk {\nb = 1\n}"},
+		{[]string{"-x", "blk {\n@*_\n!a = $_\n}"}, "blk {\nnest {\na = 1\n}\n}", "blk {\nnest {\na = 1\n}\n}"},
+		{[]string{"-x", "blk {\n@*_\n!a = 1\n}"}, "blk {\na = 2\n}", 1},
+		{[]string{"-x", "blk {\n@*_\n!nest {@*_}\n}"}, "blk {\nnest {}\n}\nblk {\na = 1\n}", "blk {\na = 1\n}"},
+		{[]string{"-x", "blk {\n@*_\n!... a = $_\n}"}, "blk {\nnest {\na = 1\n}\n}\nblk {\nb = 1\n}", "blk {\nb = 1\n}"},
+		{[]string{"-x", "blk {\n@*_\n!@x:block\n}"}, "blk {\nnest {}\n}\nblk {\na = 1\n}", "blk {\na = 1\n}"},
+		{[]string{"-x", "blk {\n!a = $_\n}"}, "blk {\nb = 1\n}", 0},
+		{[]string{"-unordered", "-x", "blk {\n!a = $_\nb = $_\n@*_\n}"}, "blk {\nb = 1\nc = 1\n}\nblk {\na = 1\nb = 1\n}", "blk {\nb = 1\nc = 1\n}"},
+		// the negative elements can reference the recorded wildcards, but don't record any
+		{[]string{"-x", "blk {\n!b = $x\na = $x\n@*_\n}"}, "blk {\na = 1\nb = 1\n}\nblk {\na = 1\nb = 2\n}", "blk {\na = 1\nb = 2\n}"},
+		{[]string{"-x", "blk {\n@*_\n!a = $x\n}", "-rx", `x=".*"`}, "blk {\nb = 1\n}", 0},
+		{[]string{"-x", "!a = $_"}, "a = 1\nb = 1", 0},
+		{[]string{"-x", "!a = $_"}, "b = 1\nc = 1", 1},
+		// logical not
+		{[]string{"-x", "!a"}, "x = !a", "!a"},
+		{[]string{"-x", "blk {\nx = !a\n}"}, "blk {\nx = !a\n}", 1},
+		// operator wildcards
+		{[]string{"-x", "$a $op $b"}, "x = a == b", "a == b"},
+		{[]string{"-x", "$a $_ $b"}, "x = a + b", "a + b"},
+		{[]string{"-x", "$a $op:cmp $b"}, "x = a + b\ny = a < b", "a < b"},
+		{[]string{"-x", "$a $op:arith $b"}, "x = a * b\ny = a && b", "a * b"},
+		{[]string{"-x", "var.$_ $op:cmp $v:number"}, "a = var.x > 1\nb = var.x + 1\nc = var.x == \"1\"", "var.x > 1"},
+		{[]string{"-x", "$a $op:logic $b"}, "x = a || b\ny = a != b", "a || b"},
+		{[]string{"-x", "$op $x"}, "x = !a", "!a"},
+		{[]string{"-x", "$op:arith $x"}, "x = !a\ny = -a", "-a"},
+		{[]string{"-x", "$op ($x)"}, "x = !(a)", "!(a)"},
+		{[]string{"-x", "$a $op $b && $c $op $d"}, "x = a == b && c == d\ny = a == b && c != d", "a == b && c == d"},
+		{[]string{"-x", "$a $op $b", "-rx", `op="^[<>]"`}, "x = a < b\ny = a == b", "a < b"},
+		{[]string{"-x", "[$a $x $b, $x]"}, "x = [a == b, c]", 0},
+		{[]string{"-x", "[for $k, $v in $m: $v]"}, "x = [for k, v in m: v]", "[for k, v in m: v]"},
+		{[]string{"-x", "a = $x:cmp"}, "", tokErr(`:1,6-7: kind "cmp" can only be used by an operator wildcard`)},
+		{[]string{"-x", "$a $op:string $b"}, "", tokErr(`:1,5-7: operator wildcard can only be constrained to one of ["arith" "cmp" "logic"]`)},
+		{[]string{"-x", "$op:cmp $x"}, "", tokErr(`:1,2-4: unary operator wildcard can't be of kind "cmp"`)},
+		// text wildcards
+		{[]string{"-x", `"prod-$x"`}, "x = \"prod-api\"\ny = \"dev-api\"", `"prod-api"`},
+		{[]string{"-x", `"prod-$x"`}, "x = \"prod-\"\ny = 1", 0},
+		{[]string{"-x", `"prod-$*x"`}, "x = \"prod-\"\ny = 1", `"prod-"`},
+		{[]string{"-x", `"v$?_.0"`}, "a = \"v1.0\"\nb = \"v10.0\"\nc = \"v.0\"", 2},
+		{[]string{"-x", `"$env-$name"`, "-rx", `name="^api-x"`}, "x = \"dev-api-x\"\ny = 1", `"dev-api-x"`},
+		{[]string{"-x", `"${var.env}-$suffix"`}, "x = \"${var.env}-api\"\ny = \"${var.region}-api\"", `"${var.env}-api"`},
+		{[]string{"-x", `"$x-$x"`}, "a = \"ab-ab\"\nb = \"ab-cd\"", `"ab-ab"`},
+		{[]string{"-x", `[$x, "$x-suffix"]`}, "a = [\"foo\", \"foo-suffix\"]\nb = [\"foo\", \"bar-suffix\"]", `["foo", "foo-suffix"]`},
+		{[]string{"-x", "<<EOT\nprod-$x\nEOT\n"}, "a = <<EOT\nprod-api\nEOT\nb = 1\n", 1},
+		// "$$" is an escaped "$"
+		{[]string{"-x", `"$$x"`}, "a = \"$x\"\nb = \"y\"", `"$x"`},
+		{[]string{"-x", `blk "$$x" {}`}, "blk \"$x\" {}\nblk \"y\" {}", `blk "$x" {}`},
+		// block labels
+		{[]string{"-x", `resource $_ "prod-$x" {}`}, "resource a \"prod-web\" {}\nresource a \"web\" {}\nresource a \"prod-\" {}", `resource a "prod-web" {}`},
+		{[]string{"-x", `resource $_ "prod-$*x" {}`}, "resource a \"prod-web\" {}\nresource a \"web\" {}\nresource a \"prod-\" {}", 2},
+		{[]string{"-x", `$_ "$x" "$x-$_" {}`}, "a \"b\" \"b-c\" {}\na \"b\" \"c-b\" {}", `a "b" "b-c" {}`},
+		// -or
+		{[]string{"-or", "(", "-x", "a = $_", ")", "(", "-x", "b = $_", ")"}, "a = 1\nb = 2\nc = 3", 2},
+		{[]string{"-or", "(", "-x", "x = $_", ")", "(", "-x", "y = $_", ")"}, "x = 1", "x = 1"},
+		{[]string{"-or", "(", "-x", "x = $_", ")", "(", "-x", "$_ = 1", ")"}, "x = 1", "x = 1"},
+		{[]string{"-or", "(", "-x", "x = $v", ")", "(", "-x", "y = $v", ")", "-x", "$v"}, "y = 2", "2"},
+		{[]string{"-x", "blk {@*_}", "-or", "(", "-g", "a = 1", ")", "(", "-g", "b = 1", ")"}, "blk {\na = 1\n}\nblk {\nb = 1\n}\nblk {\nc = 1\n}", 2},
+		{[]string{"-or", "(", "-or", "(", "-x", "a", ")", "(", "-x", "b", ")", ")", "(", "-x", "c", ")"}, "x = [a, b, c, d]", 3},
+		// -and
+		{[]string{"-and", "(", "-x", "x = $_", ")", "(", "-x", "$_ = 1", ")"}, "x = 1\ny = 1\nz = 2", "x = 1"},
+		{[]string{"-x", "blk {@*_}", "-and", "(", "-g", "a = 1", ")", "(", "-v", "b = 1", ")"}, "blk {\na = 1\n}\nblk {\na = 1\nb = 1\n}", "blk {\na = 1\n}"},
+		// the wildcard values of the groups are merged
+		{[]string{"-and", "(", "-x", "[$x, $_]", ")", "(", "-x", "[$_, $y]", ")", "-x", "$y"}, "a = [1, 2]", "2"},
+		{[]string{"-and", "(", "-x", "[$x, $_]", ")", "(", "-x", "[$_, $x]", ")"}, "a = [1, 2]", 0},
+		{[]string{"-and", "(", "-x", "[$x, $_]", ")", "(", "-x", "[$_, $x]", ")"}, "a = [1, 1]", 1},
+		{[]string{"-and", "(", "-x", "f($*p)", ")", "(", "-x", "f($*p)", ")"}, "a = f(1)\nb = f()\nc = g(1)", 2},
+		{[]string{"-and", "(", "-x", "f($*p, $_)", ")", "(", "-x", "f($_, $*p)", ")"}, "a = f(1, 2)", 0},
+		{[]string{"-and", "(", "-x", "f($*p, $_)", ")", "(", "-x", "f($_, $*p)", ")"}, "a = f(1, 1)", 1},
+		// named sets
+		{[]string{"-x", "a = $_", "-as", "a", "-x", "b = $_", "-union", "a"}, "a = 1\nb = 2\nc = 3", 2},
+		{[]string{"-x", "$_ = 1", "-as", "one", "-x", "a = $_", "-intersect", "one"}, "a = 1", "a = 1"},
+		{[]string{"-x", "$_ = 1", "-as", "one", "-x", "a = $_", "-intersect", "one"}, "a = 2", 0},
+		{[]string{"-x", "nest {@*_}", "-x", "a = $_", "-as", "nested", "-x", "a = $_", "-subtract", "nested"}, "nest {\na = 1\n}\nblk {\na = 2\n}", "a = 2"},
+		{[]string{"-x", "a = $_", "-as", "a"}, "a = 1", 1},
+		{[]string{"-or", "(", "-x", "a", ")"}, "", otherErr("`-or` must be followed by at least two groups")},
+		{[]string{"-and", "(", "-x", "a", ")", "(", ")"}, "", otherErr("the groups of `-and` must not be empty")},
+		{[]string{"-or", "(", "-x", "a", ")", "(", "-x", "b"}, "", otherErr(`unmatched "("`)},
+		{[]string{"-x", "a", ")"}, "", otherErr(`unmatched ")"`)},
+		{[]string{"-x", "a", "("}, "", otherErr("\"(\" must follow `-or`, `-and` or another group")},
+		{[]string{"-or", "(", "-x", "a", ")", "(", "-w", "a", ")"}, "", otherErr("`-w` cannot be used in a group")},
+		{[]string{"-x", "a", "-union", "b"}, "", otherErr("unknown set \"b\", which must be saved by `-as` before `-union`")},
+		{[]string{"-x", "a", "-as", ""}, "", otherErr("the name follows `-as` must not be empty")},
 	}
 
 	for i, tc := range tests {
@@ -1089,6 +1357,102 @@ foo = bar
 		{[]string{"-x", "foo = $a", "-w", "a", "-x", "foo = $a"}, "foo = bar", otherErr("`-w` must be the last command")},
 		// -w
 		{[]string{"-x", "foo = $a", "-w", "a"}, "foo = bar", "bar\n"},
+		// -w any wildcard
+		{[]string{"-x", "f($*a)", "-w", "a"}, "x = f(1, 2, 3)", "1, 2, 3\n"},
+		{[]string{"-x", "var.$*a", "-w", "a"}, "x = var.foo[0].bar", "foo[0].bar\n"},
+		{[]string{"-x", "a[$i]", "-w", "i"}, "x = [a[0], a[\"k\"], a[count.index]]", "0\n\"k\"\ncount.index\n"},
+		{[]string{"-x", "a[$i]", "-rx", `i="k"`, "-w", "i"}, "x = a[0]\ny = a[\"k\"]", "\"k\"\n"},
+		{[]string{"-x", "f(1, $*a, 3)", "-w", "a"}, "x = f(1, 2, 3)", "2\n"},
+		{[]string{"-x", "f(1, $*a, 3)", "-w", "a"}, "x = f(1, 3)", "\n"},
+		{[]string{"-x", "$a $op $b", "-w", "op"}, "x = a >= b", ">=\n"},
+		{[]string{"-x", `"prod-$x"`, "-w", "x"}, `a = "prod-api"`, "api\n"},
+		{[]string{"-x", `resource $_ "prod-$x" {@*_}`, "-w", "x"}, `resource a "prod-api" {}`, "api\n"},
+		{[]string{"-x", "blk $*a {}", "-w", "a"}, "blk \"x\" y {}", "x y\n"},
+		{[]string{"-x", "blk {\nb = 1\n@*a\n}", "-w", "a"}, "blk {\n  b = 1\n  c = 2\n  d {}\n}", "c = 2\n  d {}\n"},
+		{[]string{"-x", "{@*a, c = 3}", "-w", "a"}, "x = {a = 1, b = 2, c = 3}", "a = 1, b = 2\n"},
+		{[]string{"-unordered", "-x", "{c = 3, @*a}", "-w", "a"}, "x = {a = 1, c = 3, b = 2}", "a = 1, b = 2\n"},
+		{[]string{"-unordered", "-x", "{c = 3, @*a}", "-w", "a"}, "x = {\n  a = 1\n  c = 3\n  b = 2\n}", "a = 1\n  b = 2\n"},
+		{[]string{"-unordered", "-x", "{a = 1, @*a}", "-w", "a"}, "x = {a = 1, c = 3, # c\nb = 2}", "c = 3, # c\nb = 2\n"},
+		// -color
+		{[]string{"-color", "never", "-x", "b = foo($x, $y)"}, "a = 1\nblk {\n  b = foo(1, 2)\n}\n", "b = foo(1, 2)\n"},
+		{[]string{"-color", "always", "-x", "b = foo($x, $y)"}, "a = 1\nblk {\n  b = foo(1, 2)\n}\n", colorMatch + "b = foo(" + colorReset + wildcardColors[0] + "1" + colorReset + colorMatch + ", " + colorReset + wildcardColors[1] + "2" + colorReset + colorMatch + ")" + colorReset + "\n"},
+		{[]string{"-color", "always", "-x", "b = $x", "-w", "x"}, "a = 1\nblk {\n  b = foo(1, 2)\n}\n", wildcardColors[0] + "foo(1, 2)" + colorReset + "\n"},
+		// each line is colorized on its own
+		{[]string{"-color", "always", "-x", "blk {@*_}"}, "a = 1\nblk {\n  b = foo(1, 2)\n}\n", colorMatch + "blk {" + colorReset + "\n" + colorMatch + "  b = foo(1, 2)" + colorReset + "\n" + colorMatch + "}" + colorReset + "\n"},
+		{[]string{"-color", "always", "-C", "1", "-x", "foo($x, 2)"}, "a = 1\nblk {\n  b = foo(1, 2)\n}\n", "2-blk {\n3:  b = " + colorMatch + "foo(" + colorReset + wildcardColors[0] + "1" + colorReset + colorMatch + ", 2)" + colorReset + "\n4-}\n"},
+		{[]string{"-color", "always", "-n", "-x", "a = $x"}, "a = 1\nblk {\n  b = foo(1, 2)\n}\n", ":1:1: " + colorMatch + "a = " + colorReset + wildcardColors[0] + "1" + colorReset + "\n"},
+		// the block labels (and types) are colorized by their own ranges
+		{[]string{"-color", "always", "-x", "$t $_ $n {}"}, "resource a \"b\" {}", wildcardColors[1] + "resource" + colorReset + colorMatch + " a \"" + colorReset + wildcardColors[0] + "b" + colorReset + colorMatch + "\" {}" + colorReset + "\n"},
+		{[]string{"-color", "always", "-n", "-x", "resource $_ $n {}", "-w", "n"}, "resource a \"b\" {}", ":1:13: " + wildcardColors[0] + "b" + colorReset + "\n"},
+		// the elements of a list that are not next to each other are colorized on their own
+		{[]string{"-color", "always", "-unordered", "-x", "{c = 3, @*a}"}, "x = {a = 1, c = 3, b = 2}", colorMatch + "{" + colorReset + wildcardColors[0] + "a = 1" + colorReset + colorMatch + ", c = 3, " + colorReset + wildcardColors[0] + "b = 2" + colorReset + colorMatch + "}" + colorReset + "\n"},
+		{[]string{"-x", "[$*a]", "-rx", `a="1,2"`, "-w", "a"}, "x = [1, 2]\ny = [1, 3]", "1, 2\n"},
+		// -json
+		{[]string{"-json", "-x", "foo = $a"}, "foo = bar", `{"file":"","range":{"start":{"line":1,"column":1,"byte":0},"end":{"line":1,"column":10,"byte":9}},"text":"foo = bar","type":"Attribute","wildcards":{"a":{"range":{"start":{"line":1,"column":7,"byte":6},"end":{"line":1,"column":10,"byte":9}},"text":"bar","type":"ScopeTraversalExpr"}}}
+`},
+		{[]string{"-json", "-x", "blk $x {}"}, "blk \"a\" {}\nblk \"b\" {}", `{"file":"","range":{"start":{"line":1,"column":1,"byte":0},"end":{"line":1,"column":11,"byte":10}},"text":"blk \"a\" {}","type":"Block","wildcards":{"x":{"text":"a","type":"String"}}}
+{"file":"","range":{"start":{"line":2,"column":1,"byte":11},"end":{"line":2,"column":11,"byte":21}},"text":"blk \"b\" {}","type":"Block","wildcards":{"x":{"text":"b","type":"String"}}}
+`},
+		{[]string{"-json", "-x", "foo = $a", "-w", "a"}, "foo = bar", `{"file":"","range":{"start":{"line":1,"column":7,"byte":6},"end":{"line":1,"column":10,"byte":9}},"text":"bar","type":"ScopeTraversalExpr","wildcards":{"a":{"range":{"start":{"line":1,"column":7,"byte":6},"end":{"line":1,"column":10,"byte":9}},"text":"bar","type":"ScopeTraversalExpr"}}}
+`},
+		{[]string{"-json", "-x", "foo = [$*a]"}, "foo = [1, 2]", `{"file":"","range":{"start":{"line":1,"column":1,"byte":0},"end":{"line":1,"column":13,"byte":12}},"text":"foo = [1, 2]","type":"Attribute","wildcards":{"a":{"range":{"start":{"line":1,"column":8,"byte":7},"end":{"line":1,"column":12,"byte":11}},"text":"1, 2","type":"List"}}}
+`},
+		{[]string{"-json", "-x", "foo = [$*a]"}, "foo = []", `{"file":"","range":{"start":{"line":1,"column":1,"byte":0},"end":{"line":1,"column":9,"byte":8}},"text":"foo = []","type":"Attribute","wildcards":{"a":{"text":"","type":"List"}}}
+`},
+		{[]string{"-json", "-x", "foo = $a", "-s", "bar = $a"}, "foo = bar", otherErr("json format cannot be used with `-s`")},
+		// -s
+		{[]string{"-x", "foo = $a", "-s", "bar = $a"}, "foo = 1\nbaz = 2\n", "bar = 1\nbaz = 2\n"},
+		{[]string{"-x", "var.$x[count.index]", "-s", "var.$x[0]"}, "a = var.foo[count.index]\nb = var.bar[count.index]\n", "a = var.foo[0]\nb = var.bar[0]\n"},
+		{[]string{"-x", "$a $op $b", "-s", "$b $op $a"}, "x = a < b\n", "x = b < a\n"},
+		{[]string{"-x", `"prod-$x"`, "-s", `"dev-$x-$$x"`}, "a = \"prod-api\"\n", "a = \"dev-api-$x\"\n"},
+		{[]string{"-x", "blk $x {@*_}", "-s", `blk "new" {}`}, "blk \"old\" {\n  a = 1\n}\n", "blk \"new\" {}\n"},
+		{[]string{"-x", "@a", "-g", "a = $v", "-s", "@a"}, "a = 1\n", "a = 1\n"},
+		{[]string{"-x", "f(1, $*rest)", "-s", "g($*rest, 1)"}, "a = f(1, 2, 3)\n", "a = g(2, 3, 1)\n"},
+		{[]string{"-x", "var.$*rest", "-s", "local.$*rest"}, "a = var.foo[0].bar\n", "a = local.foo[0].bar\n"},
+		{[]string{"-x", "blk {@*body}", "-s", "new {\n  @body\n}"}, "blk {\n  a = 1\n  b = 2\n}\n", "new {\n  a = 1\n  b = 2\n}\n"},
+		// the elements matched regardless of their order are substituted on their own
+		{[]string{"-unordered", "-x", "{c = 3, @*rest}", "-s", "{c = 4, @rest}"}, "x = {a = 1, c = 3, b = 2}\n", "x = {c = 4, a = 1, b = 2}\n"},
+		{[]string{"-unordered", "-x", "blk {\nb = 2\n@*rest\n}", "-s", "blk {\n  @rest\n}"}, "blk {\n  a = 1\n  b = 2\n  c = 3\n}\n", "blk {\n  a = 1\n  c = 3\n}\n"},
+		// -s discards the substitution of nested matches
+		{[]string{"-x", "f($x)", "-s", "g($x)"}, "a = f(f(1))", "a = g(f(1))"},
+		// -s without any match
+		{[]string{"-x", "foo = $a", "-s", "bar = $a"}, "baz = 1", "baz = 1"},
+		// -d
+		{[]string{"-d", "-x", "foo = $a", "-s", "bar = $a"}, "foo = 1\nbaz = 2\n", "--- \n+++ \n@@ -1,2 +1,2 @@\n-foo = 1\n+bar = 1\n baz = 2\n"},
+		{[]string{"-d", "-x", "foo = $a", "-s", "bar = $a"}, "foo = 1", "--- \n+++ \n@@ -1 +1 @@\n-foo = 1\n\\ No newline at end of file\n+bar = 1\n\\ No newline at end of file\n"},
+		{[]string{"-d", "-x", "foo = $a", "-s", "bar = $a"}, "baz = 1\n", ""},
+		{[]string{"-d", "-x", "foo = $a"}, "foo = 1\n", otherErr("`-d` requires `-s` to be the last command")},
+		{[]string{"-d", "-i", "-x", "foo = $a", "-s", "bar = $a"}, "foo = 1\n", otherErr("`-d` and `-i` are mutually exclusive")},
+		// -c, -l, -L
+		{[]string{"-c", "-l", "-x", "foo = $a"}, "foo = 1\n", otherErr("`-c`, `-l` and `-L` are mutually exclusive")},
+		{[]string{"-l", "-x", "foo = $a", "-s", "bar = $a"}, "foo = 1\n", otherErr("`-l` cannot be used with `-s`")},
+		{[]string{"-L", "-json", "-x", "foo = $a"}, "foo = 1\n", otherErr("`-L` cannot be used with json format")},
+		// -vimgrep
+		{[]string{"-n", "-x", "foo = $a", "-s", "bar = $a"}, "foo = 1\n", otherErr("`-vimgrep` cannot be used with `-s`")},
+		{[]string{"-n", "-c", "-x", "foo = $a"}, "foo = 1\n", otherErr("`-vimgrep` cannot be used with `-c`, `-l` or `-L`")},
+		{[]string{"-vimgrep", "-x", "a = $x"}, "a = 1\nblk \"foo\" {\n\ta = {\n    b = 2\n  }\n}\n", ":1:1: a = 1\n:3:2: a = {\n"},
+		{[]string{"-n", "-x", "a = $x", "-w", "x"}, "a = 1\nblk \"foo\" {\n\ta = {\n    b = 2\n  }\n}\n", ":1:5: 1\n:3:6: {\n"},
+		// the block labels (and types) are located by their own ranges
+		{[]string{"-n", "-x", "blk $x {@*_}", "-w", "x"}, "a = 1\nblk \"foo\" {\n\ta = {\n    b = 2\n  }\n}\n", ":2:6: foo\n"},
+		{[]string{"-n", "-x", "$x foo {@*_}", "-w", "x"}, "a = 1\n  blk foo {}\n", ":2:3: blk\n"},
+		{[]string{"-n", "-x", "blk ${1,2}x {}", "-w", "x"}, "blk a \"b\" {}\n", ":1:5: a b\n"},
+		// the wildcard value without range fallbacks to the matched node
+		{[]string{"-n", "-x", `blk "prod-$x" {}`, "-w", "x"}, "a = 1\nblk \"prod-web\" {}\n", ":2:1: web\n"},
+		// -A, -B, -C
+		{[]string{"-C", "1", "-x", "foo = $a", "-s", "bar = $a"}, "foo = 1\n", otherErr("context lines cannot be used with `-s`")},
+		{[]string{"-A", "-2", "-x", "foo = $a"}, "foo = 1\n", otherErr("the number follows `-A` must >=0, got -2")},
+		{[]string{"-B", "-1", "-x", "foo = $a"}, "foo = 1\n", otherErr("the number follows `-B` must >=0, got -1")},
+		{[]string{"-C", "-1", "-x", "foo = $a"}, "foo = 1\n", otherErr("the number follows `-C` must >=0, got -1")},
+		// -color
+		{[]string{"-color", "yes", "-x", "foo = $a"}, "foo = 1\n", otherErr(`unknown color mode "yes", must be one of "auto", "always" and "never"`)},
+		// -q
+		{[]string{"-q", "-x", "foo = $a", "-s", "bar = $a"}, "foo = 1\n", otherErr("`-q` cannot be used with `-s`")},
+		// -s is not the last command
+		{[]string{"-x", "foo = $a", "-s", "bar = $a", "-x", "foo = $a"}, "foo = bar", otherErr("`-s` must be the last command")},
+		// -s references a wildcard not recorded
+		{[]string{"-x", "foo = $a", "-s", "bar = $b"}, "foo = bar", otherErr(`wildcard "b" in substitution is not recorded`)},
+		// -s with invalid substitution
+		{[]string{"-x", "foo = $a", "-s", "bar = $"}, "foo = bar", otherErr(`cannot tokenize substitution: :1,8-8: wildcard must be followed by ident, got TokenEOF`)},
 	}
 
 	for i, tc := range tests {
@@ -1102,24 +1466,34 @@ func fileTest(t *testing.T, args []string, src string, anyWant interface{}) {
 	tfatalf := func(format string, a ...interface{}) {
 		t.Fatalf("%v | %s: %s", args, src, fmt.Sprintf(format, a...))
 	}
-	opts, _, err := ParseArgs(args)
-	switch want := anyWant.(type) {
-	case wantErr:
+	checkErr := func(err error) bool {
+		want, ok := anyWant.(wantErr)
+		if !ok {
+			return false
+		}
 		if err == nil {
 			tfatalf("wanted error %q, got none", want)
 		} else if got := err.Error(); got != string(want) {
 			tfatalf("wanted error %q, got %q", want, got)
 		}
-		return
+		return true
 	}
+	opts, _, err := ParseArgs(args)
 	if err != nil {
+		if checkErr(err) {
+			return
+		}
 		tfatalf("unexpected error: %v", err)
 	}
 
 	buf := bytes.NewBufferString("")
 	opts = append(opts, OptionOutput(buf))
 	m := NewMatcher(opts...)
-	if err := m.File("", bytes.NewBufferString(src)); err != nil {
+	err = m.File("", bytes.NewBufferString(src))
+	if checkErr(err) {
+		return
+	}
+	if err != nil {
 		tfatalf("m.file() error: %v", err)
 	}
 	switch want := anyWant.(type) {
@@ -1132,3 +1506,646 @@ func fileTest(t *testing.T, args []string, src string, anyWant interface{}) {
 		panic(fmt.Sprintf("unexpected anyWant type: %T", anyWant))
 	}
 }
+
+// TestFiles runs the command lines against the files inside a temporary working directory, where "{dir}" in the
+// arguments and the wanted output (or error) is replaced by the directory.
+func TestFiles(t *testing.T) {
+	// the files to walk, which have no match so that "-L" prints the walked files
+	walkFiles := map[string]string{}
+	for _, name := range []string{
+		"main.tf",
+		"terraform.tfvars",
+		"README.md",
+		"packer/build.pkr.hcl",
+		"jobs/web.nomad",
+		"modules/foo/main.tf",
+		"modules/foo/test.tf",
+		".terraform/modules/bar/main.tf",
+		".git/config.hcl",
+		"vendor/baz/main.tf",
+	} {
+		walkFiles[name] = ""
+	}
+
+	// the files processed concurrently, whose outputs are in the order of the files
+	parallelFiles := map[string]string{}
+	var parallelOut string
+	for i := 0; i < 50; i++ {
+		parallelFiles[fmt.Sprintf("%02d.tf", i)] = fmt.Sprintf("a = %d\nblk {\n  a = %d\n}\n", i, i)
+		parallelOut += fmt.Sprintf("%d\n%d\n", i, i)
+	}
+
+	// the files to count the matches
+	countFiles := map[string]string{"0.tf": "b = 1\n", "1.tf": "a = 1\nblk {\n  a = 2\n}\n", "2.tf": "a = 3\n"}
+
+	// the rule file and the file to check against it
+	rulesFiles := map[string]string{
+		"rules.hcl": `
+rule "inbound_ssh" {
+  severity = "error"
+  message  = "NSG rule allows inbound SSH"
+  commands = [
+    { x = "resource azurerm_network_security_rule $_ {@*_}" },
+    { g = "direction = \"Inbound\"" },
+    { g = "destination_port_range = $port" },
+    { rx = "port=\"22|\\*\"" },
+  ]
+}
+
+rule "port" {
+  commands = [
+    { x = "destination_port_range = $port" },
+    { w = "port" },
+  ]
+}
+
+rule "not_https" {
+  commands = [
+    { x = "resource $_ $_ {@*_}" },
+    { or = [
+      [{ g = "destination_port_range = \"443\"" }],
+      [{ g = "destination_port_range = \"8443\"" }],
+    ] },
+    { as = "https" },
+    { x = "resource $_ $name {@*_}" },
+    { subtract = "https" },
+    { w = "name" },
+  ]
+}
+`,
+		"main.tf": `resource azurerm_network_security_rule ssh {
+  direction              = "Inbound"
+  destination_port_range = "22"
+}
+
+resource azurerm_network_security_rule https {
+  direction              = "Inbound"
+  destination_port_range = "443"
+}
+`,
+	}
+
+	// the file to output with context lines
+	contextSrc := `a = 1
+b = 2
+c = 3
+d = var.foo[count.index]
+e = 5
+f = 6
+g = 7
+h = 8
+i = 9
+blk {
+  j = var.bar[count.index]
+}
+`
+
+	tests := []struct {
+		files map[string]string
+		// wd is the working directory relative to the directory of the files, if not empty
+		wd   string
+		args []string
+		// want is the output, or the wantErr which is a substring of the error
+		want interface{}
+		// count is the number of the final matches, if no error is wanted
+		count int
+		// wantFiles is all the files inside the directory after running, if not nil
+		wantFiles map[string]string
+	}{
+		// -i writes back the substituted content
+		{
+			files:     map[string]string{"main.tf": "foo = 1\n"},
+			args:      []string{"-i", "-x", "foo = $a", "-s", "bar = $a", "main.tf"},
+			want:      "",
+			count:     1,
+			wantFiles: map[string]string{"main.tf": "bar = 1\n"},
+		},
+		// -i doesn't touch the file without any match
+		{
+			files:     map[string]string{"main.tf": "baz = 1\n"},
+			args:      []string{"-i", "-backup", "-x", "foo = $a", "-s", "bar = $a", "main.tf"},
+			want:      "",
+			wantFiles: map[string]string{"main.tf": "baz = 1\n"},
+		},
+		// -backup keeps the original file
+		{
+			files:     map[string]string{"main.tf": "foo = 1\n"},
+			args:      []string{"-i", "-backup", "-x", "foo = $a", "-s", "bar = $a", "main.tf"},
+			want:      "",
+			count:     1,
+			wantFiles: map[string]string{"main.tf": "bar = 1\n", "main.tf.orig": "foo = 1\n"},
+		},
+		// -i refuses to write invalid content
+		{
+			files:     map[string]string{"main.tf": "foo = 1\n"},
+			args:      []string{"-i", "-x", "foo = $a", "-s", "bar = ", "main.tf"},
+			want:      otherErr("processing main.tf: refuse to write as the substituted content cannot be parsed"),
+			wantFiles: map[string]string{"main.tf": "foo = 1\n"},
+		},
+		// -i stops at the first failed file, while the files before it are written
+		{
+			files:     map[string]string{"a.tf": "foo = 1\n", "b.tf": "foo = {\n", "c.tf": "foo = 3\n"},
+			args:      []string{"-i", "-j", "4", "-x", "foo = $a", "-s", "bar = $a", "a.tf", "b.tf", "c.tf"},
+			want:      otherErr("processing b.tf: cannot parse source"),
+			wantFiles: map[string]string{"a.tf": "bar = 1\n", "b.tf": "foo = {\n", "c.tf": "foo = 3\n"},
+		},
+		// -i without -s
+		{
+			files: map[string]string{"main.tf": "foo = 1\n"},
+			args:  []string{"-i", "-x", "foo = $a", "main.tf"},
+			want:  otherErr("`-i` requires `-s` to be the last command"),
+		},
+		// -backup without -i
+		{
+			files: map[string]string{"main.tf": "foo = 1\n"},
+			args:  []string{"-backup", "-x", "foo = $a", "-s", "bar = $a", "main.tf"},
+			want:  otherErr("`-backup` requires `-i`"),
+		},
+		// -d reports the diff without touching the file
+		{
+			files:     map[string]string{"main.tf": "foo = 1\n"},
+			args:      []string{"-d", "-x", "foo = $a", "-s", "bar = $a", "main.tf"},
+			want:      otherErr(ErrDiff.Error()),
+			wantFiles: map[string]string{"main.tf": "foo = 1\n"},
+		},
+		// -d reports no diff for no match
+		{
+			files:     map[string]string{"main.tf": "baz = 1\n"},
+			args:      []string{"-d", "-x", "foo = $a", "-s", "bar = $a", "main.tf"},
+			want:      "",
+			wantFiles: map[string]string{"main.tf": "baz = 1\n"},
+		},
+		// -d reports no diff for the matches substituted as is
+		{
+			files:     map[string]string{"main.tf": "foo = 1\n"},
+			args:      []string{"-d", "-x", "foo = $a", "-s", "foo = $a", "main.tf"},
+			want:      "",
+			count:     1,
+			wantFiles: map[string]string{"main.tf": "foo = 1\n"},
+		},
+		// walking directories
+		{
+			files: walkFiles,
+			args:  []string{"-L", "-x", "a", "."},
+			want:  "jobs/web.nomad\nmain.tf\nmodules/foo/main.tf\nmodules/foo/test.tf\npacker/build.pkr.hcl\nterraform.tfvars\n",
+		},
+		// explicit files are kept as is
+		{
+			files: walkFiles,
+			args:  []string{"-L", "-x", "a", "README.md", "modules"},
+			want:  "README.md\nmodules/foo/main.tf\nmodules/foo/test.tf\n",
+		},
+		{
+			files: walkFiles,
+			args:  []string{"-L", "-include", "*.md", "-include", "*.tfvars", "-x", "a", "."},
+			want:  "README.md\nterraform.tfvars\n",
+		},
+		{
+			files: walkFiles,
+			args:  []string{"-L", "-exclude", "test.tf", "-exclude", "packer", "-x", "a", "."},
+			want:  "jobs/web.nomad\nmain.tf\nmodules/foo/main.tf\nterraform.tfvars\n",
+		},
+		{
+			files: walkFiles,
+			args:  []string{"-L", "-no-ignore", "-include", "main.tf", "-x", "a", "."},
+			want:  ".terraform/modules/bar/main.tf\nmain.tf\nmodules/foo/main.tf\nvendor/baz/main.tf\n",
+		},
+		// -j
+		{
+			files: parallelFiles,
+			args:  []string{"-j", "8", "-x", "a = $x", "-w", "x", "."},
+			want:  parallelOut,
+			count: 100,
+		},
+		// the number of the final matches
+		{
+			files: countFiles,
+			args:  []string{"-x", "a = $x", "-w", "x", "0.tf", "1.tf", "2.tf"},
+			want:  "1\n2\n3\n",
+			count: 3,
+		},
+		{
+			files: countFiles,
+			args:  []string{"-x", "c = $x", "0.tf", "1.tf", "2.tf"},
+			want:  "",
+			count: 0,
+		},
+		// -q stops at the first file that has any match
+		{
+			files: countFiles,
+			args:  []string{"-q", "-x", "a = $x", "0.tf", "1.tf", "2.tf"},
+			want:  "",
+			count: 2,
+		},
+		{
+			files: countFiles,
+			args:  []string{"-q", "-j", "1", "-x", "b = $x", "0.tf", "1.tf", "2.tf"},
+			want:  "",
+			count: 1,
+		},
+		{
+			files: countFiles,
+			args:  []string{"-q", "-format", "sarif", "-x", "c = $x", "0.tf", "1.tf", "2.tf"},
+			want:  "",
+			count: 0,
+		},
+		// -c, -l, -L
+		{
+			files: countFiles,
+			args:  []string{"-c", "-x", "a = $x", "0.tf", "1.tf", "2.tf"},
+			want:  "0.tf:0\n1.tf:2\n2.tf:1\n",
+			count: 3,
+		},
+		{
+			files: countFiles,
+			args:  []string{"-l", "-x", "a = $x", "0.tf", "1.tf", "2.tf"},
+			want:  "1.tf\n2.tf\n",
+			count: 3,
+		},
+		{
+			files: countFiles,
+			args:  []string{"-L", "-x", "a = $x", "0.tf", "1.tf", "2.tf"},
+			want:  "0.tf\n",
+			count: 3,
+		},
+		{
+			files: countFiles,
+			args:  []string{"-L", "-x", "c = $x", "0.tf", "1.tf", "2.tf"},
+			want:  "0.tf\n1.tf\n2.tf\n",
+			count: 0,
+		},
+		// -A, -B, -C
+		{
+			files: map[string]string{"main.tf": contextSrc},
+			args:  []string{"-C", "1", "-x", "var.$_[count.index]", "main.tf"},
+			want: `3-c = 3
+4:d = var.foo[count.index]
+5-e = 5
+--
+10-blk {
+11:  j = var.bar[count.index]
+12-}
+`,
+			count: 2,
+		},
+		{
+			files: map[string]string{"main.tf": contextSrc},
+			args:  []string{"-A", "0", "-B", "3", "-x", "var.$_[count.index]", "main.tf"},
+			want: `1-a = 1
+2-b = 2
+3-c = 3
+4:d = var.foo[count.index]
+--
+8-h = 8
+9-i = 9
+10-blk {
+11:  j = var.bar[count.index]
+`,
+			count: 2,
+		},
+		// overlapping and adjacent windows are merged
+		{
+			files: map[string]string{"main.tf": contextSrc},
+			args:  []string{"-H", "-C", "3", "-A", "3", "-x", "var.$_[count.index]", "main.tf"},
+			want: `main.tf-1-a = 1
+main.tf-2-b = 2
+main.tf-3-c = 3
+main.tf:4:d = var.foo[count.index]
+main.tf-5-e = 5
+main.tf-6-f = 6
+main.tf-7-g = 7
+main.tf-8-h = 8
+main.tf-9-i = 9
+main.tf-10-blk {
+main.tf:11:  j = var.bar[count.index]
+main.tf-12-}
+`,
+			count: 2,
+		},
+		// multi-line match
+		{
+			files: map[string]string{"main.tf": contextSrc},
+			args:  []string{"-B", "1", "-x", "blk {@*_}", "main.tf"},
+			want: `9-i = 9
+10:blk {
+11:  j = var.bar[count.index]
+12:}
+`,
+			count: 1,
+		},
+		// the matches found by rules are tagged
+		{
+			files: map[string]string{
+				"main.tf":   contextSrc,
+				"rules.hcl": "rule \"index\" {\n  commands = [{ x = \"var.$_[count.index]\" }]\n}\n",
+			},
+			args: []string{"-f", "rules.hcl", "-B", "1", "main.tf"},
+			want: `3-c = 3
+4:[index] d = var.foo[count.index]
+--
+10-blk {
+11:[index]   j = var.bar[count.index]
+`,
+			count: 2,
+		},
+		// -f
+		{
+			files: rulesFiles,
+			args:  []string{"-f", "rules.hcl", "main.tf"},
+			want: `[inbound_ssh] resource azurerm_network_security_rule ssh {
+  direction              = "Inbound"
+  destination_port_range = "22"
+}
+[port] "22"
+[port] "443"
+[not_https] ssh
+`,
+			count: 4,
+		},
+		{
+			files: rulesFiles,
+			args:  []string{"-f", "rules.hcl", "-x", "direction = $_", "main.tf"},
+			want: `direction              = "Inbound"
+direction              = "Inbound"
+[inbound_ssh] resource azurerm_network_security_rule ssh {
+  direction              = "Inbound"
+  destination_port_range = "22"
+}
+[port] "22"
+[port] "443"
+[not_https] ssh
+`,
+			count: 6,
+		},
+		{
+			files: rulesFiles,
+			args:  []string{"-json", "-f", "rules.hcl", "-x", "direction = $_", "main.tf"},
+			want: `{"file":"main.tf","range":{"start":{"line":2,"column":3,"byte":47},"end":{"line":2,"column":37,"byte":81}},"text":"direction              = \"Inbound\"","type":"Attribute","wildcards":{}}
+{"file":"main.tf","range":{"start":{"line":7,"column":3,"byte":166},"end":{"line":7,"column":37,"byte":200}},"text":"direction              = \"Inbound\"","type":"Attribute","wildcards":{}}
+{"file":"main.tf","range":{"start":{"line":1,"column":1,"byte":0},"end":{"line":4,"column":2,"byte":115}},"text":"resource azurerm_network_security_rule ssh {\n  direction              = \"Inbound\"\n  destination_port_range = \"22\"\n}","type":"Block","wildcards":{"port":{"range":{"start":{"line":3,"column":28,"byte":109},"end":{"line":3,"column":32,"byte":113}},"text":"\"22\"","type":"TemplateExpr"}},"rule":{"id":"inbound_ssh","severity":"error","message":"NSG rule allows inbound SSH"}}
+{"file":"main.tf","range":{"start":{"line":3,"column":28,"byte":109},"end":{"line":3,"column":32,"byte":113}},"text":"\"22\"","type":"TemplateExpr","wildcards":{"port":{"range":{"start":{"line":3,"column":28,"byte":109},"end":{"line":3,"column":32,"byte":113}},"text":"\"22\"","type":"TemplateExpr"}},"rule":{"id":"port","severity":"warning"}}
+{"file":"main.tf","range":{"start":{"line":8,"column":28,"byte":228},"end":{"line":8,"column":33,"byte":233}},"text":"\"443\"","type":"TemplateExpr","wildcards":{"port":{"range":{"start":{"line":8,"column":28,"byte":228},"end":{"line":8,"column":33,"byte":233}},"text":"\"443\"","type":"TemplateExpr"}},"rule":{"id":"port","severity":"warning"}}
+{"file":"main.tf","text":"ssh","type":"String","wildcards":{"name":{"text":"ssh","type":"String"}},"rule":{"id":"not_https","severity":"warning"}}
+`,
+			count: 6,
+		},
+		// invalid rules
+		{
+			files: map[string]string{"rules.hcl": `
+rule "a" {
+  severity = "fatal"
+  commands = [{ x = "a" }]
+}`},
+			args: []string{"-f", "rules.hcl"},
+			want: otherErr(`rules.hcl:2,1-9: rule "a": unknown severity "fatal", must be one of "error", "warning" and "note"`),
+		},
+		{
+			files: map[string]string{"rules.hcl": `
+rule "a" {
+  commands = []
+}`},
+			args: []string{"-f", "rules.hcl"},
+			want: otherErr(`rules.hcl:2,1-9: rule "a": need at least one command`),
+		},
+		{
+			files: map[string]string{"rules.hcl": `
+rule "a" {
+  commands = [{ x = "a", w = "a" }]
+}`},
+			args: []string{"-f", "rules.hcl"},
+			want: otherErr(`rules.hcl:2,1-9: rule "a": each command must have exactly one key, got 2`),
+		},
+		{
+			files: map[string]string{"rules.hcl": `
+rule "a" {
+  commands = [{ x = "a = $x" }, { s = "b = $x" }]
+}`},
+			args: []string{"-f", "rules.hcl"},
+			want: otherErr("rules.hcl:2,1-9: rule \"a\": `-s` cannot be used in a rule"),
+		},
+		{
+			files: map[string]string{"rules.hcl": `
+rule "a" {
+  commands = [{ w = "x" }, { x = "a" }]
+}`},
+			args: []string{"-f", "rules.hcl"},
+			want: otherErr("rules.hcl:2,1-9: rule \"a\": `-w` must be the last command"),
+		},
+		{
+			files: map[string]string{"rules.hcl": `
+rule "a" {
+  commands = [{ y = "a" }]
+}`},
+			args: []string{"-f", "rules.hcl"},
+			want: otherErr(`rules.hcl:2,1-9: rule "a": unknown command "y"`),
+		},
+		{
+			files: map[string]string{"rules.hcl": `
+rule "a" {
+  commands = [{ or = [{ x = "a" }, { x = "b" }] }]
+}`},
+			args: []string{"-f", "rules.hcl"},
+			want: otherErr(`rules.hcl:2,1-9: rule "a": the value of command "or" must be a list of command lists`),
+		},
+		{
+			files: map[string]string{"rules.hcl": `
+rule "a" {
+  commands = [{ and = [[{ x = "a" }], [{ s = "b" }]] }]
+}`},
+			args: []string{"-f", "rules.hcl"},
+			want: otherErr("rules.hcl:2,1-9: rule \"a\": `-s` cannot be used in a rule"),
+		},
+		// the file names inside the working directory are output relative to it
+		{
+			files: map[string]string{
+				"main.tf":          "a = 1\n",
+				"work/main.tf":     "a = 2\n",
+				"work/foo/main.tf": "a = 3\n",
+				"workfoo/main.tf":  "a = 4\n",
+			},
+			wd: "work",
+			args: []string{"-H", "-x", "a = $x",
+				"main.tf", "{dir}/work/main.tf", "{dir}/work/foo/main.tf", "{dir}/workfoo/main.tf", "{dir}/main.tf"},
+			want: `main.tf:1,1-6:
+a = 2
+main.tf:1,1-6:
+a = 2
+foo/main.tf:1,1-6:
+a = 3
+{dir}/workfoo/main.tf:1,1-6:
+a = 4
+{dir}/main.tf:1,1-6:
+a = 1
+`,
+			count: 5,
+		},
+	}
+
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			dir := testDir(t, tc.files)
+			if tc.wd != "" {
+				if err := os.Chdir(filepath.Join(dir, filepath.FromSlash(tc.wd))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expand := func(s string) string {
+				return strings.ReplaceAll(s, "{dir}", dir)
+			}
+			var args []string
+			for _, arg := range tc.args {
+				args = append(args, expand(arg))
+			}
+			tfatalf := func(format string, a ...interface{}) {
+				t.Fatalf("%v: %s", args, fmt.Sprintf(format, a...))
+			}
+
+			buf := bytes.NewBufferString("")
+			var n int
+			opts, files, err := ParseArgs(args)
+			if err == nil {
+				opts = append(opts, OptionOutput(buf))
+				m := NewMatcher(opts...)
+				n, err = m.Files(files)
+			}
+			switch want := tc.want.(type) {
+			case wantErr:
+				if err == nil {
+					tfatalf("wanted error %q, got none", expand(string(want)))
+				} else if got := err.Error(); !strings.Contains(got, expand(string(want))) {
+					tfatalf("wanted error %q, got %q", expand(string(want)), got)
+				}
+			case string:
+				if err != nil {
+					tfatalf("unexpected error: %v", err)
+				}
+				if got := buf.String(); got != expand(want) {
+					tfatalf("wanted:\n%s\ngot:\n%s\n", expand(want), got)
+				}
+				if n != tc.count {
+					tfatalf("wanted %d matches, got %d", tc.count, n)
+				}
+			default:
+				panic(fmt.Sprintf("unexpected want type: %T", tc.want))
+			}
+			if tc.wantFiles != nil {
+				if got := readTestDir(t, dir); !reflect.DeepEqual(tc.wantFiles, got) {
+					tfatalf("wanted files %q, got %q", tc.wantFiles, got)
+				}
+			}
+		})
+	}
+}
+
+// testDir writes the files (the slash separated paths relative to the directory) into a temporary directory, and
+// changes the working directory to it until the test ends. It returns the absolute path of the directory.
+func testDir(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	// The temporary directory may be behind a symlink (e.g. on macOS).
+	if dir, err = os.Getwd(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// readTestDir returns all the files (the slash separated paths relative to the directory) inside the directory.
+func readTestDir(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(mustReadFile(t, path))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestFilesSARIF(t *testing.T) {
+	dir := testDir(t, map[string]string{
+		"0.tf":     "a = 1\nb = 2\n",
+		"1.tf":     "b = 1\n",
+		"2.tf":     "blk {\n  a = 3\n}\n",
+		"a b#%.tf": "a = 4\n",
+	})
+	files := []string{filepath.Join(dir, "0.tf"), filepath.Join(dir, "1.tf"), filepath.Join(dir, "2.tf"), "a b#%.tf"}
+
+	opts, _, err := ParseArgs([]string{"-format", "sarif", "-x", "a = $x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBufferString("")
+	opts = append(opts, OptionOutput(buf))
+	m := NewMatcher(opts...)
+	if _, err := m.Files(files); err != nil {
+		t.Fatal(err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("unmarshal SARIF log: %v", err)
+	}
+	if log.Version != sarifVersion || len(log.Runs) != 1 {
+		t.Fatalf("unexpected SARIF log: %s", buf.String())
+	}
+	if rules := log.Runs[0].Tool.Driver.Rules; len(rules) != 1 || rules[0].ID != sarifRuleID || rules[0].ShortDescription.Text != `-x "a = $x"` {
+		t.Fatalf("unexpected SARIF rules: %v", rules)
+	}
+	type result struct {
+		uri                                        string
+		startLine, startColumn, endLine, endColumn int
+		snippet                                    string
+	}
+	want := []result{
+		{"file://" + filepath.ToSlash(files[0]), 1, 1, 1, 6, "a = 1"},
+		{"file://" + filepath.ToSlash(files[2]), 2, 3, 2, 8, "a = 3"},
+		{"a%20b%23%25.tf", 1, 1, 1, 6, "a = 4"},
+	}
+	var got []result
+	for _, res := range log.Runs[0].Results {
+		if res.RuleID != sarifRuleID {
+			t.Fatalf("unexpected rule id %q", res.RuleID)
+		}
+		loc := res.Locations[0].PhysicalLocation
+		got = append(got, result{
+			loc.ArtifactLocation.URI,
+			loc.Region.StartLine, loc.Region.StartColumn, loc.Region.EndLine, loc.Region.EndColumn,
+			loc.Region.Snippet.Text,
+		})
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("wanted %v, got %v", want, got)
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -21,3 +21,94 @@ func OptionOutput(o io.Writer) Option {
 		m.out = o
 	}
 }
+
+func OptionWriteInPlace(inplace bool) Option {
+	return func(m *Matcher) {
+		m.inplace = inplace
+	}
+}
+
+func OptionBackup(backup bool) Option {
+	return func(m *Matcher) {
+		m.backup = backup
+	}
+}
+
+func OptionDiff(diff bool) Option {
+	return func(m *Matcher) {
+		m.diff = diff
+	}
+}
+
+func OptionInclude(globs []string) Option {
+	return func(m *Matcher) {
+		m.includes = globs
+	}
+}
+
+func OptionExclude(globs []string) Option {
+	return func(m *Matcher) {
+		m.excludes = globs
+	}
+}
+
+func OptionNoIgnore(noIgnore bool) Option {
+	return func(m *Matcher) {
+		m.noIgnore = noIgnore
+	}
+}
+
+func OptionJobs(jobs int) Option {
+	return func(m *Matcher) {
+		m.jobs = jobs
+	}
+}
+
+func OptionFormat(format OutputFormat) Option {
+	return func(m *Matcher) {
+		m.format = format
+	}
+}
+
+func OptionRule(rule Rule) Option {
+	return func(m *Matcher) {
+		m.rules = append(m.rules, rule)
+	}
+}
+
+func OptionQuiet(quiet bool) Option {
+	return func(m *Matcher) {
+		m.quiet = quiet
+	}
+}
+
+func OptionSummary(summary Summary) Option {
+	return func(m *Matcher) {
+		m.summary = summary
+	}
+}
+
+func OptionVimgrep(vimgrep bool) Option {
+	return func(m *Matcher) {
+		m.vimgrep = vimgrep
+	}
+}
+
+func OptionContext(before, after int) Option {
+	return func(m *Matcher) {
+		m.before = before
+		m.after = after
+	}
+}
+
+func OptionColor(mode ColorMode) Option {
+	return func(m *Matcher) {
+		m.colorMode = mode
+	}
+}
+
+func OptionUnordered(unordered bool) Option {
+	return func(m *Matcher) {
+		m.unordered = unordered
+	}
+}
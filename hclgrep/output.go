@@ -0,0 +1,364 @@
+package hclgrep
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+type OutputFormat string
+
+const (
+	FormatText  OutputFormat = "text"
+	FormatJSON               = "json"
+	FormatSARIF              = "sarif"
+)
+
+// Summary is how the final matches of each file are summarized, instead of being outputted one by one.
+type Summary string
+
+const (
+	SummaryNone                Summary = ""
+	SummaryCount                       = "count"
+	SummaryFilesWithMatches            = "files-with-matches"
+	SummaryFilesWithoutMatches         = "files-without-matches"
+)
+
+// output outputs the final submatches of one file to matcher's out. In text format, the matches found by a rule are
+// prefixed with the rule id, e.g. "[rule_id] ".
+func (m *Matcher) output(fileName string, subs []submatch) error {
+	switch m.summary {
+	case SummaryCount:
+		_, err := fmt.Fprintf(m.out, "%s:%d\n", fileName, len(subs))
+		return err
+	case SummaryFilesWithMatches:
+		if len(subs) == 0 {
+			return nil
+		}
+		_, err := fmt.Fprintln(m.out, fileName)
+		return err
+	case SummaryFilesWithoutMatches:
+		if len(subs) != 0 {
+			return nil
+		}
+		_, err := fmt.Fprintln(m.out, fileName)
+		return err
+	}
+
+	switch m.format {
+	case FormatJSON:
+		return m.outputJSON(fileName, subs)
+	case FormatSARIF:
+		m.collectSARIF(fileName, subs)
+		return nil
+	}
+
+	if m.vimgrep {
+		return m.outputVimgrep(fileName, subs)
+	}
+	if m.before != 0 || m.after != 0 {
+		return m.outputContext(fileName, subs)
+	}
+
+	for _, sub := range subs {
+		tag := ruleTag(sub)
+		if name, ok := writeName(sub.rule.cmds); ok {
+			val := sub.values[name]
+			if rng, ok := m.substitutionRange(val); ok && m.color {
+				fmt.Fprintf(m.out, "%s%s\n", tag, m.colorize(rng.Start.Byte, rng.End.Byte, sub))
+				continue
+			}
+			b, _ := m.substitutionBytes(val)
+			fmt.Fprintf(m.out, "%s%s\n", tag, b)
+			continue
+		}
+
+		rng := sub.node.Range()
+		output := m.colorize(rng.Start.Byte, rng.End.Byte, sub)
+		if m.prefix {
+			rng.Filename = displayName(rng.Filename)
+			output = fmt.Sprintf("%s:\n%s", rng, output)
+		}
+
+		fmt.Fprintf(m.out, "%s%s\n", tag, output)
+	}
+	return nil
+}
+
+// outputVimgrep outputs one line per match in the form of "file:line:column: text", where the column is the 1-based
+// byte offset in the line, and the text is the first line of the match. In case the last command is "-w", the location
+// and the text are about the wildcard value, instead of the matched node.
+func (m *Matcher) outputVimgrep(fileName string, subs []submatch) error {
+	fileName = displayName(fileName)
+	for _, sub := range subs {
+		target := m.target(sub)
+		// Fallback to the matched node for the wildcard value that has no range (e.g. a string recorded by a text
+		// wildcard).
+		pos := sub.node.Range().Start
+		rng, hasRange := m.substitutionRange(target)
+		if !hasRange {
+			rng, hasRange = m.labelRange(target)
+		}
+		if hasRange {
+			pos = rng.Start
+		} else if len(target.List) != 0 {
+			// e.g. the block labels recorded by an any wildcard
+			if rng, ok := m.labelRange(target.List[0]); ok {
+				pos = rng.Start
+			}
+		}
+		b, _ := m.substitutionBytes(target)
+		if i := bytes.IndexByte(b, '\n'); i != -1 {
+			b = b[:i]
+		}
+		text := string(b)
+		if hasRange && m.color {
+			text = m.colorize(pos.Byte, pos.Byte+len(b), sub)
+		}
+		lineStart := bytes.LastIndexByte(m.b[:pos.Byte], '\n') + 1
+		if _, err := fmt.Fprintf(m.out, "%s:%d:%d: %s%s\n", fileName, pos.Line, pos.Byte-lineStart+1, ruleTag(sub), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ruleTag returns the tag prefixed to the text output of a match found by a rule, e.g. "[rule_id] ".
+func ruleTag(sub submatch) string {
+	if sub.rule.id == "" {
+		return ""
+	}
+	return fmt.Sprintf("[%s] ", sub.rule.id)
+}
+
+// displayName returns the file name to output, which is made relative to the working directory if the file is an
+// absolute path inside it.
+func displayName(fileName string) string {
+	if !filepath.IsAbs(fileName) {
+		return fileName
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return fileName
+	}
+	rel, err := filepath.Rel(wd, fileName)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fileName
+	}
+	return rel
+}
+
+// writeName returns the wildcard name of the "-w" command, if it is the last command.
+func writeName(cmds []Cmd) (string, bool) {
+	cmd := cmds[len(cmds)-1]
+	if cmd.name != CmdNameWrite {
+		return "", false
+	}
+	return string(cmd.value.Value().(CmdValueString)), true
+}
+
+// jsonMatch is the JSON representation of a match, which is outputted in JSON mode.
+type jsonMatch struct {
+	File      string                  `json:"file"`
+	Range     *jsonRange              `json:"range,omitempty"`
+	Text      string                  `json:"text"`
+	Type      string                  `json:"type"`
+	Wildcards map[string]jsonWildcard `json:"wildcards"`
+	Rule      *jsonRule               `json:"rule,omitempty"`
+}
+
+// jsonRule is the JSON representation of the rule that a match is found by, which is omitted for the commands.
+type jsonRule struct {
+	ID       string `json:"id"`
+	Severity string `json:"severity"`
+	Message  string `json:"message,omitempty"`
+}
+
+// jsonWildcard is the JSON representation of a recorded wildcard value.
+type jsonWildcard struct {
+	Range *jsonRange `json:"range,omitempty"`
+	Text  string     `json:"text"`
+	Type  string     `json:"type"`
+}
+
+type jsonRange struct {
+	Start jsonPos `json:"start"`
+	End   jsonPos `json:"end"`
+}
+
+type jsonPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+func newJSONRange(rng hcl.Range) *jsonRange {
+	return &jsonRange{
+		Start: jsonPos{Line: rng.Start.Line, Column: rng.Start.Column, Byte: rng.Start.Byte},
+		End:   jsonPos{Line: rng.End.Line, Column: rng.End.Column, Byte: rng.End.Byte},
+	}
+}
+
+// target returns what to output for the submatch, which is the wildcard value in case the last command (of its rule)
+// is "-w", otherwise the matched node.
+func (m *Matcher) target(sub submatch) substitution {
+	if name, ok := writeName(sub.rule.cmds); ok {
+		return sub.values[name]
+	}
+	return newNodeSubstitution(sub.node)
+}
+
+// outputJSON outputs one JSON object per match (i.e. JSON Lines). In case the last command is "-w", the object is
+// about the wildcard value, instead of the matched node. The matches found by a rule are tagged with the rule.
+func (m *Matcher) outputJSON(fileName string, subs []submatch) error {
+	enc := json.NewEncoder(m.out)
+	for _, sub := range subs {
+		v := m.jsonWildcard(m.target(sub))
+		match := jsonMatch{
+			File:      fileName,
+			Range:     v.Range,
+			Text:      v.Text,
+			Type:      v.Type,
+			Wildcards: map[string]jsonWildcard{},
+		}
+		for name, val := range sub.values {
+			match.Wildcards[name] = m.jsonWildcard(val)
+		}
+		if sub.rule.id != "" {
+			match.Rule = &jsonRule{
+				ID:       sub.rule.id,
+				Severity: sub.rule.severity,
+				Message:  sub.rule.message,
+			}
+		}
+		if err := enc.Encode(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Matcher) jsonWildcard(val substitution) jsonWildcard {
+	var v jsonWildcard
+	if b, ok := m.substitutionBytes(val); ok {
+		v.Text = string(b)
+	}
+	if rng, ok := m.substitutionRange(val); ok {
+		v.Range = newJSONRange(rng)
+	}
+	v.Type = substitutionType(val)
+	return v
+}
+
+// substitutionRange returns the source range of the substitution, if any.
+func (m *Matcher) substitutionRange(val substitution) (hcl.Range, bool) {
+	switch {
+	case val.String != nil:
+		return hcl.Range{}, false
+	case val.Node != nil:
+		return val.Node.Range(), true
+	case val.ObjectConsItem != nil:
+		return hcl.RangeBetween(val.ObjectConsItem.KeyExpr.Range(), val.ObjectConsItem.ValueExpr.Range()), true
+	case val.Traverser != nil:
+		return traverserRange(*val.Traverser), true
+	case val.List != nil:
+		return m.listRange(val.List)
+	case val.Operator != nil:
+		return val.Operator.rng, true
+	default:
+		panic("never reach here")
+	}
+}
+
+// labelRange returns the source range of the block label (or block type) that the string substitution is taken from,
+// which excludes the quotes of a quoted label.
+func (m *Matcher) labelRange(val substitution) (hcl.Range, bool) {
+	if val.LabelRange == nil {
+		return hcl.Range{}, false
+	}
+	rng := *val.LabelRange
+	if rng.End.Byte-rng.Start.Byte >= 2 && m.b[rng.Start.Byte] == '"' {
+		rng.Start.Byte, rng.Start.Column = rng.Start.Byte+1, rng.Start.Column+1
+		rng.End.Byte, rng.End.Column = rng.End.Byte-1, rng.End.Column-1
+	}
+	return rng, true
+}
+
+// listRange returns the source range from the first element of the list to the last one, if the elements are next to
+// each other in the source, i.e. only separated by commas, newlines, comments (or the dots of a traversal). It is not
+// the case for the elements
+// matched regardless of their order, e.g. "a = 1" and "b = 2" from "{a = 1, c = 3, b = 2}".
+func (m *Matcher) listRange(list []substitution) (hcl.Range, bool) {
+	if len(list) == 0 {
+		return hcl.Range{}, false
+	}
+	rng, ok := m.substitutionRange(list[0])
+	if !ok {
+		return hcl.Range{}, false
+	}
+	for _, elem := range list[1:] {
+		next, ok := m.substitutionRange(elem)
+		if !ok || next.Start.Byte < rng.End.Byte {
+			return hcl.Range{}, false
+		}
+		toks, _ := hclsyntax.LexConfig(m.b[rng.End.Byte:next.Start.Byte], "", hcl.InitialPos)
+		for _, tok := range toks {
+			switch tok.Type {
+			case hclsyntax.TokenComma, hclsyntax.TokenNewline, hclsyntax.TokenComment, hclsyntax.TokenDot, hclsyntax.TokenEOF:
+			default:
+				return hcl.Range{}, false
+			}
+		}
+		rng = hcl.RangeBetween(rng, next)
+	}
+	return rng, true
+}
+
+// traverserRange returns the source range of the traverser, which excludes the leading "." of an attribute.
+func traverserRange(trav hcl.Traverser) hcl.Range {
+	rng := trav.SourceRange()
+	if attr, ok := trav.(hcl.TraverseAttr); ok && rng.End.Byte-rng.Start.Byte > len(attr.Name) {
+		rng.Start = hcl.Pos{
+			Line:   rng.End.Line,
+			Column: rng.End.Column - utf8.RuneCountInString(attr.Name),
+			Byte:   rng.End.Byte - len(attr.Name),
+		}
+	}
+	return rng
+}
+
+// substitutionType returns the type name of the substitution, e.g. "Attribute", "ScopeTraversalExpr", "TraverseAttr".
+func substitutionType(val substitution) string {
+	switch {
+	case val.String != nil:
+		return "String"
+	case val.Node != nil:
+		return typeName(val.Node)
+	case val.ObjectConsItem != nil:
+		return typeName(val.ObjectConsItem)
+	case val.Traverser != nil:
+		return typeName(*val.Traverser)
+	case val.List != nil:
+		return "List"
+	case val.Operator != nil:
+		return "Operator"
+	default:
+		panic("never reach here")
+	}
+}
+
+func typeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
@@ -6,17 +6,60 @@ import (
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 )
 
-func compileExpr(expr string) (hclsyntax.Node, error) {
-	toks, err := tokenize(expr)
+func compileExpr(expr string) (hclsyntax.Node, []wildcard, error) {
+	toks, wildcards, err := tokenize(expr)
 	if err != nil {
-		return nil, fmt.Errorf("cannot tokenize expr: %v", err)
+		return nil, nil, fmt.Errorf("cannot tokenize expr: %v", err)
 	}
 
-	p := toks.Bytes()
-	node, diags := parse(p, "", hcl.InitialPos)
+	node, diags := parseTokens(toks, wildcards)
 	if diags.HasErrors() {
-		return nil, fmt.Errorf("cannot parse expr: %v", diags.Error())
+		return nil, nil, fmt.Errorf("cannot parse expr: %v", diags.Error())
 	}
+	return node, wildcards, nil
+}
+
+// parseTokens parses the tokens of a pattern, where the operation of each operator wildcard is replaced by the
+// placeholder operation of the wildcard (see markOperators).
+func parseTokens(toks fullTokens, wildcards []wildcard) (hclsyntax.Node, hcl.Diagnostics) {
+	src, offsets := toks.bytesWithOffsets()
+	node, diags := parse(src, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	// The operator wildcards are identified by the offsets of their placeholders, which are between the operator
+	// (if any) and the operand(s) of the operation.
+	opAt := map[int]*hclsyntax.Operation{}
+	for i, t := range toks {
+		if t.Type == hclsyntax.TokenType(TokenOpWildcard) {
+			opAt[offsets[i]] = wildcards[t.Index].op
+		}
+	}
+	if len(opAt) == 0 {
+		return node, nil
+	}
+	between := func(start, end hcl.Pos) *hclsyntax.Operation {
+		for offset, op := range opAt {
+			if start.Byte <= offset && offset < end.Byte {
+				return op
+			}
+		}
+		return nil
+	}
+	hclsyntax.VisitAll(node, func(n hclsyntax.Node) hcl.Diagnostics {
+		switch n := n.(type) {
+		case *hclsyntax.BinaryOpExpr:
+			if op := between(n.LHS.Range().End, n.RHS.Range().Start); op != nil {
+				n.Op = op
+			}
+		case *hclsyntax.UnaryOpExpr:
+			if op := between(n.SrcRange.Start, n.Val.Range().Start); op != nil {
+				n.Op = op
+			}
+		}
+		return nil
+	})
 	return node, nil
 }
 
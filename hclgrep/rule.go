@@ -0,0 +1,196 @@
+package hclgrep
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNote    = "note"
+)
+
+// Rule is a named pipeline of commands. The matches of a rule are tagged with its id.
+type Rule struct {
+	// id is empty for the rule made up of the commands from the command line.
+	id       string
+	severity string
+	message  string
+	cmds     []Cmd
+}
+
+// ruleFileSchema is the schema of a rule file, e.g.
+//
+//	rule "nsg_allow_ssh" {
+//	  severity = "error"
+//	  message  = "NSG rule allows inbound SSH"
+//	  commands = [
+//	    { x = "resource azurerm_network_security_rule $_ {@*_}" },
+//	    { g = "direction = \"Inbound\"" },
+//	  ]
+//	}
+var ruleFileSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "rule", LabelNames: []string{"id"}},
+	},
+}
+
+var ruleSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "severity"},
+		{Name: "message"},
+		{Name: "commands", Required: true},
+	},
+}
+
+// loadRules loads the rules from a rule file, with their commands compiled.
+func loadRules(fileName string) ([]Rule, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("reading rule file: %w", err)
+	}
+	f, diags := hclsyntax.ParseConfig(b, fileName, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("cannot parse rule file: %s", diags.Error())
+	}
+	content, diags := f.Body.Content(ruleFileSchema)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("cannot decode rule file: %s", diags.Error())
+	}
+
+	var rules []Rule
+	for _, block := range content.Blocks {
+		id := block.Labels[0]
+		rule, err := newRule(id, block.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %q: %v", block.DefRange, id, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func newRule(id string, body hcl.Body) (Rule, error) {
+	if id == "" {
+		return Rule{}, fmt.Errorf("empty id")
+	}
+	content, diags := body.Content(ruleSchema)
+	if diags.HasErrors() {
+		return Rule{}, fmt.Errorf(diags.Error())
+	}
+	rule := Rule{id: id}
+	for name, dst := range map[string]*string{"severity": &rule.severity, "message": &rule.message} {
+		attr, ok := content.Attributes[name]
+		if !ok {
+			continue
+		}
+		v, err := stringValue(attr.Expr)
+		if err != nil {
+			return Rule{}, fmt.Errorf("%s %v", name, err)
+		}
+		*dst = v
+	}
+	switch rule.severity {
+	case "":
+		rule.severity = SeverityWarning
+	case SeverityError, SeverityWarning, SeverityNote:
+	default:
+		return Rule{}, fmt.Errorf("unknown severity %q, must be one of %q, %q and %q", rule.severity, SeverityError, SeverityWarning, SeverityNote)
+	}
+
+	commands, diags := content.Attributes["commands"].Expr.Value(nil)
+	if diags.HasErrors() {
+		return Rule{}, fmt.Errorf(diags.Error())
+	}
+	if commands.IsNull() || !(commands.Type().IsTupleType() || commands.Type().IsListType()) {
+		return Rule{}, fmt.Errorf("commands must be a list of objects")
+	}
+	if commands.LengthInt() == 0 {
+		return Rule{}, fmt.Errorf("need at least one command")
+	}
+	cmds, err := ruleCmds(commands)
+	if err != nil {
+		return Rule{}, err
+	}
+	rule.cmds = cmds
+	if err := compileCmds(rule.cmds); err != nil {
+		return Rule{}, err
+	}
+	return rule, nil
+}
+
+// ruleCmds returns the commands of the list of command objects. The value of "or" and "and" is a list of groups, each of
+// which is a list of command objects, e.g. { or = [[{ x = "a" }], [{ x = "b" }]] }.
+func ruleCmds(commands cty.Value) ([]Cmd, error) {
+	var cmds []Cmd
+	for it := commands.ElementIterator(); it.Next(); {
+		_, command := it.Element()
+		if command.IsNull() || !(command.Type().IsObjectType() || command.Type().IsMapType()) {
+			return nil, fmt.Errorf("each command must be an object")
+		}
+		if n := command.LengthInt(); n != 1 {
+			return nil, fmt.Errorf("each command must have exactly one key, got %d", n)
+		}
+		for it := command.ElementIterator(); it.Next(); {
+			k, v := it.Element()
+			name := k.AsString()
+			switch CmdName(name) {
+			case CmdNameMatch, CmdNameFilterMatch, CmdNameFilterUnMatch, CmdNameParent, CmdNameRx, CmdNameWrite,
+				CmdNameAs, CmdNameUnion, CmdNameIntersect, CmdNameSubtract:
+			case CmdNameOr, CmdNameAnd:
+				if v.IsNull() || !(v.Type().IsTupleType() || v.Type().IsListType()) {
+					return nil, fmt.Errorf("the value of command %q must be a list of command lists", name)
+				}
+				cmd := Cmd{name: CmdName(name)}
+				for it := v.ElementIterator(); it.Next(); {
+					_, group := it.Element()
+					if group.IsNull() || !(group.Type().IsTupleType() || group.Type().IsListType()) {
+						return nil, fmt.Errorf("the value of command %q must be a list of command lists", name)
+					}
+					groupCmds, err := ruleCmds(group)
+					if err != nil {
+						return nil, err
+					}
+					cmd.groups = append(cmd.groups, groupCmds)
+				}
+				cmds = append(cmds, cmd)
+				continue
+			case CmdNameSubstitute:
+				return nil, fmt.Errorf("`-%s` cannot be used in a rule", name)
+			default:
+				return nil, fmt.Errorf("unknown command %q", name)
+			}
+			if v.IsNull() || v.Type() != cty.String {
+				return nil, fmt.Errorf("the value of command %q must be a string", name)
+			}
+			cmds = append(cmds, Cmd{name: CmdName(name), src: v.AsString()})
+		}
+	}
+	return cmds, nil
+}
+
+// stringValue evaluates the expression, which can't reference any variable, as a string.
+func stringValue(expr hcl.Expression) (string, error) {
+	v, diags := expr.Value(nil)
+	if diags.HasErrors() {
+		return "", fmt.Errorf(diags.Error())
+	}
+	if v.IsNull() || v.Type() != cty.String {
+		return "", fmt.Errorf("must be a string")
+	}
+	return v.AsString(), nil
+}
+
+// describe returns the message of the rule, which defaults to the command line form of its commands.
+func (r *Rule) describe() string {
+	if r.message != "" {
+		return r.message
+	}
+	return fmt.Sprintf("Matched by %s", queryString(r.cmds))
+}
@@ -0,0 +1,184 @@
+package hclgrep
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	// sarifRuleID is the rule id of the matches of the commands, which are not from a rule file.
+	sarifRuleID = "hclgrep"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int          `json:"startLine"`
+	StartColumn int          `json:"startColumn"`
+	EndLine     int          `json:"endLine"`
+	EndColumn   int          `json:"endColumn"`
+	Snippet     sarifMessage `json:"snippet"`
+}
+
+// collectSARIF collects the SARIF results of one file, which are written at the end of Files.
+func (m *Matcher) collectSARIF(fileName string, subs []submatch) {
+	for _, sub := range subs {
+		// Fallback to the matched node for the wildcard value that has no range (e.g. a block label).
+		rng := sub.node.Range()
+		target := m.target(sub)
+		if r, ok := m.substitutionRange(target); ok {
+			rng = r
+		}
+		b, _ := m.substitutionBytes(target)
+		m.sarifResults = append(m.sarifResults, sarifResult{
+			RuleID:  sarifID(sub.rule),
+			Level:   sub.rule.severity,
+			Message: sarifMessage{Text: sub.rule.describe()},
+			Locations: []sarifLocation{
+				{
+					PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: sarifArtifactLocation{URI: sarifURI(fileName)},
+						Region: sarifRegion{
+							StartLine:   rng.Start.Line,
+							StartColumn: rng.Start.Column,
+							EndLine:     rng.End.Line,
+							EndColumn:   rng.End.Column,
+							Snippet:     sarifMessage{Text: string(b)},
+						},
+					},
+				},
+			},
+		})
+	}
+}
+
+// writeSARIF writes the SARIF log of the results to matcher's out.
+func (m *Matcher) writeSARIF(results []sarifResult) error {
+	if results == nil {
+		results = []sarifResult{}
+	}
+	rules := make([]sarifRule, 0, len(m.rules))
+	for i := range m.rules {
+		rule := &m.rules[i]
+		desc := queryString(rule.cmds)
+		if rule.message != "" {
+			desc = rule.message
+		}
+		rules = append(rules, sarifRule{
+			ID:               sarifID(rule),
+			ShortDescription: sarifMessage{Text: desc},
+		})
+	}
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{
+			{
+				Tool: sarifTool{
+					Driver: sarifDriver{
+						Name:           "hclgrep",
+						InformationURI: "https://github.com/magodo/hclgrep",
+						Rules:          rules,
+					},
+				},
+				Results: results,
+			},
+		},
+	}
+	enc := json.NewEncoder(m.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+// sarifID returns the SARIF rule id of the rule.
+func sarifID(rule *Rule) string {
+	if rule.id == "" {
+		return sarifRuleID
+	}
+	return rule.id
+}
+
+// sarifURI returns the artifact URI of the file, which is relative for relative paths. The path is percent-encoded.
+func sarifURI(fileName string) string {
+	uri := url.URL{Path: filepath.ToSlash(fileName)}
+	if !filepath.IsAbs(fileName) {
+		return uri.String()
+	}
+	if !strings.HasPrefix(uri.Path, "/") {
+		// e.g. Windows path "C:/foo"
+		uri.Path = "/" + uri.Path
+	}
+	uri.Scheme = "file"
+	return uri.String()
+}
+
+// queryString returns the command line form of the commands, e.g. `-x "a = $x" -w "x"`.
+func queryString(cmds []Cmd) string {
+	parts := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		if cmd.groups == nil {
+			parts = append(parts, fmt.Sprintf("-%s %q", cmd.name, cmd.src))
+			continue
+		}
+		part := fmt.Sprintf("-%s", cmd.name)
+		for _, group := range cmd.groups {
+			part += fmt.Sprintf(" %s %s %s", groupStart, queryString(group), groupEnd)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,197 @@
+package hclgrep
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// template is a compiled substitution pattern, which is the source text of
+// the pattern together with the wildcard references found inside it.
+type template struct {
+	src  []byte
+	refs []templateRef
+}
+
+// templateRef is a wildcard reference (e.g. "$x", "@x") inside a template,
+// which spans the bytes [start, end) of the template source. A reference
+// without name is an escaped "$" inside the literal text of a string.
+type templateRef struct {
+	name  string
+	start int
+	end   int
+}
+
+// compileTemplate finds out all the wildcard references in the substitution pattern.
+func compileTemplate(src string) (template, error) {
+	tokens, err := lex(src, false)
+	if err != nil {
+		return template{}, fmt.Errorf("cannot tokenize substitution: %v", err)
+	}
+
+	tmpl := template{src: []byte(src)}
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.Type == hclsyntax.TokenQuotedLit || tok.Type == hclsyntax.TokenStringLit {
+			// The text wildcards inside the literal text, e.g. "prefix-$x", which is split by the lexer at each "$".
+			start := tok.Range.Start.Byte
+			for i+1 < len(tokens) && tokens[i+1].Type == tok.Type {
+				i++
+			}
+			for _, ref := range scanText(tmpl.src[start:tokens[i].Range.End.Byte]) {
+				tmpl.refs = append(tmpl.refs, templateRef{
+					name:  ref.name,
+					start: start + ref.start,
+					end:   start + ref.end,
+				})
+			}
+			continue
+		}
+		if !(tok.Type == hclsyntax.TokenInvalid &&
+			(string(tok.Bytes) == wildcardLit || string(tok.Bytes) == attrWildcardLit)) {
+			continue
+		}
+		start := tok.Range.Start.Byte
+		i++
+		switch tokens[i].Type {
+		case hclsyntax.TokenStar, hclsyntax.TokenPlus, hclsyntax.TokenQuestion:
+			i++
+		}
+		tok = tokens[i]
+		if tok.Type != hclsyntax.TokenIdent {
+			return template{}, fmt.Errorf("cannot tokenize substitution: %v: wildcard must be followed by ident, got %v",
+				tok.Range, tok.Type)
+		}
+		tmpl.refs = append(tmpl.refs, templateRef{
+			name:  string(tok.Bytes),
+			start: start,
+			end:   tok.Range.End.Byte,
+		})
+	}
+	return tmpl, nil
+}
+
+// render renders the template by replacing each wildcard reference with the source of its recorded value.
+func (m *Matcher) render(tmpl template, values map[string]substitution) ([]byte, error) {
+	var buf bytes.Buffer
+	var offset int
+	for _, ref := range tmpl.refs {
+		if ref.name == "" {
+			buf.Write(tmpl.src[offset:ref.start])
+			buf.WriteString(wildcardLit)
+			offset = ref.end
+			continue
+		}
+		val, ok := values[ref.name]
+		if !ok {
+			return nil, fmt.Errorf("wildcard %q in substitution is not recorded", ref.name)
+		}
+		b, ok := m.substitutionBytes(val)
+		if !ok {
+			return nil, fmt.Errorf("wildcard %q in substitution has no source representation", ref.name)
+		}
+		buf.Write(tmpl.src[offset:ref.start])
+		buf.Write(b)
+		offset = ref.end
+	}
+	buf.Write(tmpl.src[offset:])
+	return buf.Bytes(), nil
+}
+
+// edit replaces the bytes [start, end) of the source with text.
+type edit struct {
+	start int
+	end   int
+	text  []byte
+}
+
+// substitute renders the template for each submatch, and returns the source with all the matched nodes replaced.
+func (m *Matcher) substitute(tmpl template, subs []submatch) ([]byte, error) {
+	edits := make([]edit, 0, len(subs))
+	for _, sub := range subs {
+		text, err := m.render(tmpl, sub.values)
+		if err != nil {
+			return nil, err
+		}
+		rng := sub.node.Range()
+		edits = append(edits, edit{
+			start: rng.Start.Byte,
+			end:   rng.End.Byte,
+			text:  text,
+		})
+	}
+	return applyEdits(m.b, edits), nil
+}
+
+// applyEdits applies the edits to the source in one pass. The edits are applied in the order of their offset, where
+// an edit that overlaps with a previous applied one (e.g. the edit of a nested match) is discarded.
+func applyEdits(src []byte, edits []edit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+	var buf bytes.Buffer
+	offset, last := 0, -1
+	for _, e := range edits {
+		if e.start < offset || e.start == last {
+			continue
+		}
+		buf.Write(src[offset:e.start])
+		buf.Write(e.text)
+		offset, last = e.end, e.start
+	}
+	buf.Write(src[offset:])
+	return buf.Bytes()
+}
+
+// backupSuffix is the suffix of the backup file of the original file, when writing in place.
+const backupSuffix = ".orig"
+
+// writeFile writes the substituted content back to the file atomically, via a temporary file in the same directory
+// which is then renamed to the file. It refuses to write if the substituted content is not a valid HCL file.
+func (m *Matcher) writeFile(fileName string, orig, b []byte) error {
+	if bytes.Equal(b, orig) {
+		return nil
+	}
+	if _, diags := hclsyntax.ParseConfig(b, fileName, hcl.InitialPos); diags.HasErrors() {
+		return fmt.Errorf("refuse to write as the substituted content cannot be parsed: %s", diags.Error())
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	if m.backup {
+		if err := os.WriteFile(fileName+backupSuffix, orig, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("writing backup file: %w", err)
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".hclgrep-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	tmpName := f.Name()
+	// Remove the temporary file in case of any failure below, this is a no-op once it is renamed.
+	defer os.Remove(tmpName)
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("writing temporary file: %w", err)
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		f.Close()
+		return fmt.Errorf("changing mode of temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+	if err := os.Rename(tmpName, fileName); err != nil {
+		return fmt.Errorf("renaming temporary file: %w", err)
+	}
+	return nil
+}
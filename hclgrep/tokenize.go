@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
+
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 )
@@ -18,12 +21,16 @@ const (
 	TokenWildcardAny
 	TokenAttrWildcard
 	TokenAttrWildcardAny
+	// TokenOpWildcard is a wildcard in the operator position, whose bytes are the placeholder operator.
+	TokenOpWildcard
 )
 
 type fullToken struct {
 	Type  hclsyntax.TokenType
 	Bytes []byte
 	Range hcl.Range
+	// Index is the index of the wildcard in the wildcard table of the pattern, only for the wildcard tokens.
+	Index int
 }
 
 type fullTokens []fullToken
@@ -31,16 +38,45 @@ type fullTokens []fullToken
 const (
 	wildcardLit     = "$"
 	attrWildcardLit = "@"
+	constraintLit   = "~"
+	alternationLit  = "|"
 )
 
-// tokenize create fullTokens by substituting the wildcard token in the source.
-// Also it removes any leading newline.
-func tokenize(src string) (fullTokens, error) {
+// wildcard is a wildcard occurrence in a pattern, which is referenced by its index in the wildcard table of the
+// pattern.
+type wildcard struct {
+	name string
+	any  bool
+	// min and max bound the number of elements that the any wildcard matches, where max is -1 if unbounded, e.g.
+	// $*x (0, -1), $+x (1, -1), $?x (0, 1), ${1,3}x (1, 3)
+	min, max int
+	// kind constrains the kind of the wildcard value (each element for the any wildcard), if not nil, e.g. $x:string
+	kind kindFunc
+	// rx constrains the literal value of the wildcard (each element for the any wildcard), if not nil, e.g. $x~"^foo"
+	rx *regexp.Regexp
+	// alts are the alternatives of an alternation, one of which must match the wildcard value, e.g. (1 | "1")
+	alts []hclsyntax.Node
+	// opKind is the kind of the operator wildcard, if it is constrained to one, e.g. $a $op:cmp $b
+	opKind string
+	// op is the placeholder operation of the operator wildcard in the parsed pattern, if it is one
+	op *hclsyntax.Operation
+	// text tells whether it is a text wildcard inside the literal text of a template, e.g. "prefix-$x", whose min and
+	// max bound the number of characters it matches
+	text bool
+}
+
+// lex lexes the source of a pattern (or a substitution pattern) as an expression, ignoring the diagnostics of the
+// wildcards, and of the constraints and alternations if patternOps is true.
+func lex(src string, patternOps bool) (hclsyntax.Tokens, error) {
 	tokens, _diags := hclsyntax.LexExpression([]byte(src), "", hcl.InitialPos)
 
 	var diags hcl.Diagnostics
 	for _, diag := range _diags {
-		if tok := string(diag.Subject.SliceBytes([]byte(src))); diag.Summary == "Invalid character" && (tok == wildcardLit || tok == attrWildcardLit) {
+		tok := string(diag.Subject.SliceBytes([]byte(src)))
+		if diag.Summary == "Invalid character" && (tok == wildcardLit || tok == attrWildcardLit) {
+			continue
+		}
+		if patternOps && diag.Summary == "Unsupported operator" && (tok == constraintLit || tok == alternationLit) {
 			continue
 		}
 		diags = diags.Append(diag)
@@ -48,6 +84,16 @@ func tokenize(src string) (fullTokens, error) {
 	if diags.HasErrors() {
 		return nil, errors.New(diags.Error())
 	}
+	return tokens, nil
+}
+
+// tokenize create fullTokens by substituting the wildcard token in the source, together with the wildcard table.
+// Also it removes any leading newline.
+func tokenize(src string) (fullTokens, []wildcard, error) {
+	tokens, err := lex(src, true)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var start int
 	for start = 0; start < len(tokens) && tokens[start].Type == hclsyntax.TokenNewline; start++ {
@@ -55,7 +101,7 @@ func tokenize(src string) (fullTokens, error) {
 
 	var remaining []fullToken
 	for _, tok := range tokens[start:] {
-		remaining = append(remaining, fullToken{tok.Type, tok.Bytes, tok.Range})
+		remaining = append(remaining, fullToken{Type: tok.Type, Bytes: tok.Bytes, Range: tok.Range})
 		if tok.Type == hclsyntax.TokenEOF {
 			break
 		}
@@ -68,6 +114,7 @@ func tokenize(src string) (fullTokens, error) {
 
 	var (
 		toks              []fullToken
+		wildcards         []wildcard
 		wildcardTokenType = hclsyntax.TokenNil
 	)
 	t := next()
@@ -75,6 +122,9 @@ func tokenize(src string) (fullTokens, error) {
 		if t.Type == hclsyntax.TokenEOF {
 			break
 		}
+		if t.Type == hclsyntax.TokenBitwiseNot {
+			return nil, nil, fmt.Errorf("%v: %q must follow a wildcard", t.Range, constraintLit)
+		}
 		if !(t.Type == hclsyntax.TokenInvalid &&
 			(string(t.Bytes) == wildcardLit || string(t.Bytes) == attrWildcardLit)) {
 			// regular HCL
@@ -95,7 +145,25 @@ func tokenize(src string) (fullTokens, error) {
 			panic("never reach here")
 		}
 		t = next()
-		if string(t.Bytes) == string(hclsyntax.TokenStar) {
+		wc := wildcard{}
+		quantified := true
+		switch t.Type {
+		case hclsyntax.TokenStar:
+			wc.min, wc.max = 0, -1
+		case hclsyntax.TokenPlus:
+			wc.min, wc.max = 1, -1
+		case hclsyntax.TokenQuestion:
+			wc.min, wc.max = 0, 1
+		case hclsyntax.TokenOBrace:
+			var err error
+			wc.min, wc.max, err = parseQuantifier(t, next)
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			quantified = false
+		}
+		if quantified {
 			switch wildcardTokenType {
 			case hclsyntax.TokenType(TokenWildcard):
 				wildcardTokenType = hclsyntax.TokenType(TokenWildcardAny)
@@ -105,36 +173,754 @@ func tokenize(src string) (fullTokens, error) {
 			t = next()
 		}
 		if t.Type != hclsyntax.TokenIdent {
-			return nil, fmt.Errorf("%v: wildcard must be followed by ident, got %v",
+			return nil, nil, fmt.Errorf("%v: wildcard must be followed by ident, got %v",
 				t.Range, t.Type)
 		}
+		wc.name = string(t.Bytes)
+		wc.any = quantified
 		toks = append(toks, fullToken{
 			Type:  wildcardTokenType,
 			Bytes: t.Bytes,
 			Range: t.Range,
+			Index: len(wildcards),
 		})
+		end := t.Range.End.Byte
 		t = next()
+
+		// The kind must immediately follow the name (e.g. "$x:string"), so that "$x : $y" is still a conditional.
+		// Neither is "$x:y" a kind, unless "y" is the name of any kind.
+		if t.Type == hclsyntax.TokenColon && t.Range.Start.Byte == end && remaining[0].Type == hclsyntax.TokenIdent && remaining[0].Range.Start.Byte == t.Range.End.Byte && isKindName(string(remaining[0].Bytes)) {
+			kindSets := []map[string]kindFunc{exprKinds, opKinds}
+			switch wildcardTokenType {
+			case hclsyntax.TokenType(TokenAttrWildcard), hclsyntax.TokenType(TokenAttrWildcardAny):
+				kindSets = []map[string]kindFunc{attrKinds}
+			case hclsyntax.TokenType(TokenWildcardAny):
+				kindSets = []map[string]kindFunc{exprKinds}
+			}
+			t = next()
+			name := string(t.Bytes)
+			if _, ok := opKinds[name]; ok && len(kindSets) == 2 {
+				wc.opKind = name
+			}
+			var kind kindFunc
+			for _, kinds := range kindSets {
+				if k, ok := kinds[name]; ok {
+					kind = k
+				}
+			}
+			if kind == nil {
+				return nil, nil, fmt.Errorf("%v: unknown kind %q, must be one of %q", t.Range, t.Bytes, kindNames(kindSets...))
+			}
+			wc.kind = kind
+			t = next()
+		}
+
+		if t.Type == hclsyntax.TokenBitwiseNot {
+			rng := t.Range
+			t = next()
+			if t.Type != hclsyntax.TokenOQuote {
+				return nil, nil, fmt.Errorf("%v: %q must be followed by a quoted string, got %v", t.Range, constraintLit, t.Type)
+			}
+			quoted := append([]byte{}, t.Bytes...)
+			for t = next(); t.Type != hclsyntax.TokenCQuote; t = next() {
+				if t.Type == hclsyntax.TokenEOF {
+					return nil, nil, fmt.Errorf("%v: unterminated quoted string", t.Range)
+				}
+				quoted = append(quoted, t.Bytes...)
+			}
+			quoted = append(quoted, t.Bytes...)
+			rx, err := compileConstraint(quoted)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v: %v", hcl.RangeBetween(rng, t.Range), err)
+			}
+			wc.rx = rx
+			t = next()
+		}
+		wildcards = append(wildcards, wc)
+	}
+
+	if err := markOperators([]byte(src), toks, wildcards); err != nil {
+		return nil, nil, err
+	}
+	toks, wildcards = expandText(toks, wildcards)
+	toks, wildcards, err = expandAlt(toks, wildcards)
+	if err != nil {
+		return nil, nil, err
+	}
+	toks, err = expandDeep(expandNot(toks))
+	if err != nil {
+		return nil, nil, err
+	}
+	return toks, wildcards, nil
+}
+
+// markOperators turns the expression wildcards in the operator position (e.g. "$a $op $b", "$op $a") to the operator
+// wildcards, whose bytes are the placeholder operators for parsing. A wildcard is in the operator position if it is
+// followed by a space and an operand, and it is neither a traversal step nor in a block header. The binary operators
+// (i.e. following an operand) are marked before the unary ones, so that "$a $op $b" is not taken as "$a" applied to
+// "$op $b". The placeholder is of the kind of the wildcard (i.e. "==", "+" or "||" for a binary operator, "-" or "!"
+// for a unary operator), which also decides the precedence of the operator. The unconstrained operator wildcard has
+// the precedence of "==" (or "!").
+func markOperators(src []byte, toks []fullToken, wildcards []wildcard) error {
+	binaryPlaceholders := map[string]string{"": "==", "cmp": "==", "arith": "+", "logic": "||"}
+	unaryPlaceholders := map[string]string{"": "!", "arith": "-", "logic": "!"}
+	for _, binary := range []bool{true, false} {
+		for i, t := range toks {
+			if t.Type != hclsyntax.TokenType(TokenWildcard) || binary != (i > 0 && endsOperand(toks[i-1])) {
+				continue
+			}
+			if i+1 == len(toks) || !startsOperand(toks[i+1]) || !followsSpace(src, toks[i+1]) ||
+				(i > 0 && toks[i-1].Type == hclsyntax.TokenDot) || isBlockHeader(toks, i) {
+				continue
+			}
+			wc := &wildcards[t.Index]
+			if wc.kind != nil && wc.opKind == "" {
+				return fmt.Errorf("%v: operator wildcard can only be constrained to one of %q", t.Range, kindNames(opKinds))
+			}
+			placeholders := binaryPlaceholders
+			if !binary {
+				placeholders = unaryPlaceholders
+			}
+			placeholder, ok := placeholders[wc.opKind]
+			if !ok {
+				return fmt.Errorf("%v: unary operator wildcard can't be of kind %q", t.Range, wc.opKind)
+			}
+			wc.op = &hclsyntax.Operation{}
+			toks[i].Type = hclsyntax.TokenType(TokenOpWildcard)
+			toks[i].Bytes = []byte(placeholder)
+		}
+	}
+	for _, t := range toks {
+		if t.Type == hclsyntax.TokenType(TokenWildcard) && wildcards[t.Index].opKind != "" {
+			return fmt.Errorf("%v: kind %q can only be used by an operator wildcard", t.Range, wildcards[t.Index].opKind)
+		}
+	}
+	return nil
+}
+
+// followsSpace tells whether the token follows a space, e.g. "$op (a)" rather than the call "$f(a)".
+func followsSpace(src []byte, t fullToken) bool {
+	i := t.Range.Start.Byte
+	if t.Type == hclsyntax.TokenType(TokenWildcard) {
+		// the range of the wildcard token is the one of its name
+		i -= len(wildcardLit)
+	}
+	return i > 0 && i <= len(src) && (src[i-1] == ' ' || src[i-1] == '\t')
+}
+
+// keywords are the idents that are the keywords of the for expression, rather than the operands.
+var keywords = map[string]bool{"for": true, "in": true, "if": true}
+
+// endsOperand tells whether the token can be the last one of an operand.
+func endsOperand(t fullToken) bool {
+	switch t.Type {
+	case hclsyntax.TokenIdent:
+		return !keywords[string(t.Bytes)]
+	case hclsyntax.TokenType(TokenWildcard),
+		hclsyntax.TokenNumberLit,
+		hclsyntax.TokenCQuote,
+		hclsyntax.TokenCHeredoc,
+		hclsyntax.TokenCParen,
+		hclsyntax.TokenCBrack,
+		hclsyntax.TokenCBrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// startsOperand tells whether the token can be the first one of an operand.
+func startsOperand(t fullToken) bool {
+	switch t.Type {
+	case hclsyntax.TokenIdent:
+		return !keywords[string(t.Bytes)]
+	case hclsyntax.TokenType(TokenWildcard),
+		hclsyntax.TokenNumberLit,
+		hclsyntax.TokenOQuote,
+		hclsyntax.TokenOHeredoc,
+		hclsyntax.TokenOParen,
+		hclsyntax.TokenOBrack,
+		hclsyntax.TokenOBrace,
+		hclsyntax.TokenBang,
+		hclsyntax.TokenMinus:
+		return true
+	default:
+		return false
+	}
+}
+
+// isBlockHeader tells whether the i-th token is in the header of a block, i.e. the type and the labels (or the
+// alternations of them) from the start of a body element to the open brace of the block body.
+func isBlockHeader(toks []fullToken, i int) bool {
+	isLabel := func(t fullToken) bool {
+		switch t.Type {
+		case hclsyntax.TokenIdent,
+			hclsyntax.TokenType(TokenWildcard),
+			hclsyntax.TokenType(TokenWildcardAny),
+			hclsyntax.TokenOQuote,
+			hclsyntax.TokenQuotedLit,
+			hclsyntax.TokenCQuote:
+			return true
+		default:
+			return false
+		}
 	}
+	start := i
+	for start > 0 {
+		if isLabel(toks[start-1]) {
+			start--
+			continue
+		}
+		if toks[start-1].Type != hclsyntax.TokenCParen {
+			break
+		}
+		depth := 0
+		for start--; start >= 0; start-- {
+			switch toks[start].Type {
+			case hclsyntax.TokenCParen:
+				depth++
+			case hclsyntax.TokenOParen:
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+		}
+		if start < 0 {
+			return false
+		}
+	}
+	if start > 0 && toks[start-1].Type != hclsyntax.TokenNewline && toks[start-1].Type != hclsyntax.TokenOBrace {
+		return false
+	}
+	end := i
+	for end < len(toks) {
+		if isLabel(toks[end]) {
+			end++
+			continue
+		}
+		if toks[end].Type != hclsyntax.TokenOParen {
+			break
+		}
+		depth := 0
+		for ; end < len(toks); end++ {
+			switch toks[end].Type {
+			case hclsyntax.TokenOParen:
+				depth++
+			case hclsyntax.TokenCParen:
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+		}
+		if end == len(toks) {
+			return false
+		}
+		end++
+	}
+	return end < len(toks) && toks[end].Type == hclsyntax.TokenOBrace
+}
 
+// expandText expands the text wildcards inside the literal text of the quoted strings and heredocs into the
+// interpolations of the wildcards, e.g. "prefix-$x" to "prefix-${$x}", which are appended to the wildcard table. As a
+// block label can't be a template, the text wildcards of it are kept as "${<wildcard ident>}" in the label instead,
+// which is escaped as "$${<wildcard ident>}" in the source. The text wildcard "$x" matches one or more characters, while the quantified ones "$*x", "$+x" and "$?x"
+// match zero or more, one or more, and zero or one characters respectively.
+func expandText(toks []fullToken, wildcards []wildcard) ([]fullToken, []wildcard) {
+	var out []fullToken
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.Type != hclsyntax.TokenQuotedLit && t.Type != hclsyntax.TokenStringLit {
+			out = append(out, t)
+			continue
+		}
+		// The literal text is split by the lexer at each "$", e.g. "prod-", "$", "x".
+		for i+1 < len(toks) && toks[i+1].Type == t.Type {
+			i++
+			t.Bytes = append(append([]byte{}, t.Bytes...), toks[i].Bytes...)
+			t.Range = hcl.RangeBetween(t.Range, toks[i].Range)
+		}
+		refs := scanText(t.Bytes)
+		if len(refs) == 0 {
+			out = append(out, t)
+			continue
+		}
+		if isBlockHeader(toks, i) {
+			var (
+				lit    []byte
+				offset int
+			)
+			for _, ref := range refs {
+				lit = append(lit, t.Bytes[offset:ref.start]...)
+				offset = ref.end
+				if ref.name == "" {
+					lit = append(lit, wildcardLit...)
+					continue
+				}
+				wc := newTextWildcard(ref)
+				lit = append(lit, "$${"+wildIdent(ref.name, wc.any, len(wildcards))+"}"...)
+				wildcards = append(wildcards, wc)
+			}
+			t.Bytes = append(lit, t.Bytes[offset:]...)
+			out = append(out, t)
+			continue
+		}
+		rangeOf := func(start, end int) hcl.Range {
+			rng := t.Range
+			rng.Start.Byte, rng.Start.Column = t.Range.Start.Byte+start, t.Range.Start.Column+start
+			rng.End = hcl.Pos{Line: rng.Start.Line, Byte: rng.Start.Byte + end - start, Column: rng.Start.Column + end - start}
+			return rng
+		}
+		var (
+			lit    []byte
+			offset int
+		)
+		for _, ref := range refs {
+			lit = append(lit, t.Bytes[offset:ref.start]...)
+			offset = ref.end
+			if ref.name == "" {
+				lit = append(lit, wildcardLit...)
+				continue
+			}
+			if len(lit) != 0 {
+				out = append(out, fullToken{Type: t.Type, Bytes: lit, Range: rangeOf(ref.start-len(lit), ref.start)})
+				lit = nil
+			}
+			wc := newTextWildcard(ref)
+			typ := hclsyntax.TokenType(TokenWildcard)
+			if wc.any {
+				typ = hclsyntax.TokenType(TokenWildcardAny)
+			}
+			rng := rangeOf(ref.start, ref.end)
+			out = append(out,
+				fullToken{Type: hclsyntax.TokenTemplateInterp, Bytes: []byte("${"), Range: rng},
+				fullToken{Type: typ, Bytes: []byte(ref.name), Range: rng, Index: len(wildcards)},
+				fullToken{Type: hclsyntax.TokenTemplateSeqEnd, Bytes: []byte("}"), Range: rng},
+			)
+			wildcards = append(wildcards, wc)
+		}
+		lit = append(lit, t.Bytes[offset:]...)
+		if len(lit) != 0 {
+			out = append(out, fullToken{Type: t.Type, Bytes: lit, Range: rangeOf(len(t.Bytes)-len(lit), len(t.Bytes))})
+		}
+	}
+	return out, wildcards
+}
+
+// newTextWildcard returns the text wildcard of the reference.
+func newTextWildcard(ref textRef) wildcard {
+	wc := wildcard{name: ref.name, min: 1, max: -1, text: true}
+	if ref.quantifier != 0 {
+		wc.any = true
+		switch ref.quantifier {
+		case '*':
+			wc.min = 0
+		case '?':
+			wc.min, wc.max = 0, 1
+		}
+	}
+	return wc
+}
+
+// textRef is a wildcard reference inside the literal text of a template, which spans the bytes [start, end) of the
+// text. A reference without name is an escaped "$" (i.e. "$$").
+type textRef struct {
+	name       string
+	quantifier byte
+	start, end int
+}
+
+// scanText finds the wildcard references inside the literal text of a template. A reference is "$" followed by an
+// optional quantifier (i.e. "*", "+" or "?") and a name of letters, digits and underscores, e.g. "$x", "$*_". A "$" not
+// followed by a name is kept as is, and "$$" is an escaped "$", except for "$${" which is the escaped interpolation.
+func scanText(text []byte) []textRef {
+	isNameByte := func(b byte, first bool) bool {
+		return b == '_' || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || (!first && '0' <= b && b <= '9')
+	}
+	var refs []textRef
+	for i := 0; i < len(text); i++ {
+		if text[i] != '$' {
+			continue
+		}
+		if i+1 < len(text) && text[i+1] == '$' {
+			if i+2 < len(text) && text[i+2] == '{' {
+				i += 2
+				continue
+			}
+			refs = append(refs, textRef{start: i, end: i + 2})
+			i++
+			continue
+		}
+		ref := textRef{start: i}
+		j := i + 1
+		if j < len(text) && (text[j] == '*' || text[j] == '+' || text[j] == '?') {
+			ref.quantifier = text[j]
+			j++
+		}
+		k := j
+		for k < len(text) && isNameByte(text[k], k == j) {
+			k++
+		}
+		if k == j {
+			continue
+		}
+		ref.name, ref.end = string(text[j:k]), k
+		refs = append(refs, ref)
+		i = k - 1
+	}
+	return refs
+}
+
+// parseQuantifier parses the bounded quantifier starting from the open brace, i.e. "{n}", "{n,}" or "{n,m}", which
+// returns the minimum and the maximum (-1 if unbounded) number of elements.
+func parseQuantifier(open fullToken, next func() fullToken) (int, int, error) {
+	invalid := func(t fullToken) error {
+		return fmt.Errorf("%v: invalid quantifier, must be one of %q, %q and %q", hcl.RangeBetween(open.Range, t.Range), "{n}", "{n,}", "{n,m}")
+	}
+	number := func(t fullToken) (int, bool) {
+		if t.Type != hclsyntax.TokenNumberLit {
+			return 0, false
+		}
+		n, err := strconv.Atoi(string(t.Bytes))
+		return n, err == nil
+	}
+	t := next()
+	min, ok := number(t)
+	if !ok {
+		return 0, 0, invalid(t)
+	}
+	max := min
+	if t = next(); t.Type == hclsyntax.TokenComma {
+		max = -1
+		if t = next(); t.Type != hclsyntax.TokenCBrace {
+			if max, ok = number(t); !ok {
+				return 0, 0, invalid(t)
+			}
+			t = next()
+		}
+	}
+	if t.Type != hclsyntax.TokenCBrace {
+		return 0, 0, invalid(t)
+	}
+	if max == 0 || (max != -1 && min > max) {
+		return 0, 0, fmt.Errorf("%v: invalid quantifier, the maximum must be positive and no less than the minimum", hcl.RangeBetween(open.Range, t.Range))
+	}
+	return min, max, nil
+}
+
+// expandAlt expands each alternation "(<alt> | <alt> ...)" into an anonymous wildcard, which is appended to the
+// wildcard table with the alternatives. The alternatives are either all expressions (or strings, e.g. a block type),
+// or all attributes/blocks. The parentheses of a function call (i.e. right after the function name) and of the
+// expression form of the descendant operator are kept, e.g. "f(a | b)".
+func expandAlt(toks []fullToken, wildcards []wildcard) ([]fullToken, []wildcard, error) {
+	var out []fullToken
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.Type != hclsyntax.TokenOParen {
+			out = append(out, t)
+			continue
+		}
+		end, alts := splitAlt(toks, i)
+		if end == -1 || len(alts) == 1 {
+			// Not an alternation, the inner parentheses are expanded in the following iterations.
+			out = append(out, t)
+			continue
+		}
+
+		var (
+			nodes    []hclsyntax.Node
+			elements int
+		)
+		for _, alt := range alts {
+			alt.toks = trimNewlines(alt.toks)
+			if len(alt.toks) == 0 {
+				return nil, nil, fmt.Errorf("%v: empty alternative", alt.sep.Range)
+			}
+			var err error
+			alt.toks, wildcards, err = expandAlt(alt.toks, wildcards)
+			if err != nil {
+				return nil, nil, err
+			}
+			alt.toks, err = expandDeep(expandNot(alt.toks))
+			if err != nil {
+				return nil, nil, err
+			}
+			rng := hcl.RangeBetween(alt.toks[0].Range, alt.toks[len(alt.toks)-1].Range)
+			node, diags := parseTokens(alt.toks, wildcards)
+			if diags.HasErrors() {
+				return nil, nil, fmt.Errorf("%v: cannot parse alternative: %v", rng, diags.Error())
+			}
+			switch node.(type) {
+			case *hclsyntax.Attribute, *hclsyntax.Block:
+				elements++
+			case *hclsyntax.Body:
+				return nil, nil, fmt.Errorf("%v: alternative must be an expression, an attribute or a block", rng)
+			}
+			nodes = append(nodes, node)
+		}
+		if elements != 0 && elements != len(nodes) {
+			return nil, nil, fmt.Errorf("%v: alternatives must be all expressions or all attributes/blocks", hcl.RangeBetween(t.Range, toks[end].Range))
+		}
+
+		wc := fullToken{
+			Type:  hclsyntax.TokenType(TokenWildcard),
+			Bytes: []byte("_"),
+			Range: hcl.RangeBetween(t.Range, toks[end].Range),
+			Index: len(wildcards),
+		}
+		if elements != 0 {
+			wc.Type = hclsyntax.TokenType(TokenAttrWildcard)
+		}
+		wildcards = append(wildcards, wildcard{name: "_", alts: nodes})
+
+		var keepParen bool
+		if len(out) != 0 {
+			switch prev := out[len(out)-1]; prev.Type {
+			case hclsyntax.TokenIdent:
+				keepParen = prev.Range.End.Byte == t.Range.Start.Byte
+			case hclsyntax.TokenEllipsis:
+				keepParen = elements == 0
+			}
+		}
+		if keepParen {
+			out = append(out, t, wc, toks[end])
+		} else {
+			out = append(out, wc)
+		}
+		i = end
+	}
+	return out, wildcards, nil
+}
+
+// trimNewlines removes the leading and trailing newlines of the tokens.
+func trimNewlines(toks []fullToken) []fullToken {
+	for len(toks) != 0 && toks[0].Type == hclsyntax.TokenNewline {
+		toks = toks[1:]
+	}
+	for len(toks) != 0 && toks[len(toks)-1].Type == hclsyntax.TokenNewline {
+		toks = toks[:len(toks)-1]
+	}
+	return toks
+}
+
+// alternative is the tokens of an alternative, together with its leading separator (i.e. "(" or "|").
+type alternative struct {
+	sep  fullToken
+	toks []fullToken
+}
+
+// splitAlt splits the tokens inside the parentheses starting at the index by the "|" at the top level. It returns
+// the index of the closing parenthesis, which is -1 if not found.
+func splitAlt(toks []fullToken, start int) (int, []alternative) {
+	alts := []alternative{{sep: toks[start]}}
+	depth := 0
+	for i := start + 1; i < len(toks); i++ {
+		t := toks[i]
+		switch t.Type {
+		case hclsyntax.TokenOBrace, hclsyntax.TokenOBrack, hclsyntax.TokenOParen,
+			hclsyntax.TokenTemplateInterp, hclsyntax.TokenTemplateControl, hclsyntax.TokenOQuote, hclsyntax.TokenOHeredoc:
+			depth++
+		case hclsyntax.TokenCBrace, hclsyntax.TokenCBrack, hclsyntax.TokenCParen,
+			hclsyntax.TokenTemplateSeqEnd, hclsyntax.TokenCQuote, hclsyntax.TokenCHeredoc:
+			if depth == 0 {
+				if t.Type != hclsyntax.TokenCParen {
+					return -1, nil
+				}
+				return i, alts
+			}
+			depth--
+		case hclsyntax.TokenBitwiseOr:
+			if depth == 0 {
+				alts = append(alts, alternative{sep: t})
+				continue
+			}
+		}
+		last := &alts[len(alts)-1]
+		last.toks = append(last.toks, t)
+	}
+	return -1, nil
+}
+
+// compileConstraint compiles the regexp of a quoted string (e.g. "foo.*"), which can't contain any interpolation. The
+// regexp must match the whole literal, the same as "-rx".
+func compileConstraint(quoted []byte) (*regexp.Regexp, error) {
+	expr, diags := hclsyntax.ParseExpression(quoted, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, errors.New(diags.Error())
+	}
+	v, diags := expr.Value(nil)
+	if diags.HasErrors() {
+		return nil, errors.New(diags.Error())
+	}
+	return compileAnchored(v.AsString())
+}
+
+// expandDeep expands the descendant operator ("..."):
+//   - Body form: "... <attribute/block>" as an element of a body is wrapped into a block "hclgrepdeep { <element> }".
+//   - Expression form: "...(<expr>)" is turned to a function call "hclgrepdeep(<expr>)".
+//
+// The other "..." (i.e. the expansion of a function call argument, or of a for expression grouping) are kept as is.
+func expandDeep(toks []fullToken) ([]fullToken, error) {
+	for i, t := range toks {
+		if t.Type != hclsyntax.TokenEllipsis || i+1 == len(toks) {
+			continue
+		}
+		if toks[i+1].Type == hclsyntax.TokenOParen {
+			out := append([]fullToken{}, toks[:i]...)
+			out = append(out, fullToken{Type: hclsyntax.TokenIdent, Bytes: []byte(deepName), Range: t.Range})
+			rest, err := expandDeep(toks[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			return append(out, rest...), nil
+		}
+		if i != 0 && toks[i-1].Type != hclsyntax.TokenNewline && toks[i-1].Type != hclsyntax.TokenOBrace {
+			continue
+		}
+		switch toks[i+1].Type {
+		case hclsyntax.TokenIdent,
+			hclsyntax.TokenType(TokenWildcard),
+			hclsyntax.TokenType(TokenWildcardAny),
+			hclsyntax.TokenType(TokenAttrWildcard),
+			hclsyntax.TokenType(TokenAttrWildcardAny):
+		default:
+			return nil, fmt.Errorf("%v: \"...\" must be followed by an attribute or a block, got %v", toks[i+1].Range, toks[i+1].Type)
+		}
+
+		end := elementEnd(toks, i+1)
+		elem, err := expandDeep(toks[i+1 : end])
+		if err != nil {
+			return nil, err
+		}
+		rest, err := expandDeep(toks[end:])
+		if err != nil {
+			return nil, err
+		}
+		out := wrapElement(toks[:i], deepName, t.Range, elem)
+		return append(out, rest...), nil
+	}
 	return toks, nil
 }
 
+// expandNot expands the negative element "!<attribute/block>" of a body, which is wrapped into a block
+// "hclgrepnot { <element> }". The element can also be in the descendant form, e.g. "!... a = 1".
+//
+// The other "!" (i.e. the logical not of an expression, e.g. "!a") are kept as is.
+func expandNot(toks []fullToken) []fullToken {
+	for i, t := range toks {
+		if t.Type != hclsyntax.TokenBang || i+1 == len(toks) {
+			continue
+		}
+		if i != 0 && toks[i-1].Type != hclsyntax.TokenNewline && toks[i-1].Type != hclsyntax.TokenOBrace {
+			continue
+		}
+		if !isElementStart(toks[i+1:]) {
+			continue
+		}
+		end := elementEnd(toks, i+1)
+		out := wrapElement(toks[:i], notName, t.Range, expandNot(toks[i+1:end]))
+		return append(out, expandNot(toks[end:])...)
+	}
+	return toks
+}
+
+// isElementStart tells whether the tokens start with an attribute or a block (or its descendant form), rather than an
+// expression.
+func isElementStart(toks []fullToken) bool {
+	switch toks[0].Type {
+	case hclsyntax.TokenEllipsis,
+		hclsyntax.TokenType(TokenAttrWildcard),
+		hclsyntax.TokenType(TokenAttrWildcardAny):
+		return true
+	case hclsyntax.TokenIdent,
+		hclsyntax.TokenType(TokenWildcard),
+		hclsyntax.TokenType(TokenWildcardAny):
+		if len(toks) < 2 {
+			return false
+		}
+		// The name is followed by the "=" of an attribute, or the labels (or the body) of a block.
+		switch toks[1].Type {
+		case hclsyntax.TokenEqual,
+			hclsyntax.TokenOBrace,
+			hclsyntax.TokenIdent,
+			hclsyntax.TokenOQuote,
+			hclsyntax.TokenType(TokenWildcard),
+			hclsyntax.TokenType(TokenWildcardAny):
+			return true
+		}
+	}
+	return false
+}
+
+// elementEnd returns the end of the body element starting from the start, which is the newline (or the closing brace
+// of the body) at the same depth.
+func elementEnd(toks []fullToken, start int) int {
+	end := start
+	depth := 0
+	for ; end < len(toks); end++ {
+		switch toks[end].Type {
+		case hclsyntax.TokenOBrace, hclsyntax.TokenOBrack, hclsyntax.TokenOParen,
+			hclsyntax.TokenTemplateInterp, hclsyntax.TokenTemplateControl, hclsyntax.TokenOQuote, hclsyntax.TokenOHeredoc:
+			depth++
+		case hclsyntax.TokenCBrace, hclsyntax.TokenCBrack, hclsyntax.TokenCParen,
+			hclsyntax.TokenTemplateSeqEnd, hclsyntax.TokenCQuote, hclsyntax.TokenCHeredoc:
+			if depth == 0 {
+				return end
+			}
+			depth--
+		case hclsyntax.TokenNewline:
+			if depth == 0 {
+				return end
+			}
+		}
+	}
+	return end
+}
+
+// wrapElement appends the body element wrapped into a block of the name (i.e. "<name> { <element> }") to the tokens.
+func wrapElement(toks []fullToken, name string, rng hcl.Range, elem []fullToken) []fullToken {
+	out := append([]fullToken{}, toks...)
+	out = append(out,
+		fullToken{Type: hclsyntax.TokenIdent, Bytes: []byte(name), Range: rng},
+		fullToken{Type: hclsyntax.TokenOBrace, Bytes: []byte("{"), Range: rng},
+		fullToken{Type: hclsyntax.TokenNewline, Bytes: []byte("\n"), Range: rng},
+	)
+	out = append(out, elem...)
+	return append(out,
+		fullToken{Type: hclsyntax.TokenNewline, Bytes: []byte("\n"), Range: rng},
+		fullToken{Type: hclsyntax.TokenCBrace, Bytes: []byte("}"), Range: rng},
+	)
+}
+
 func (toks fullTokens) Bytes() []byte {
+	b, _ := toks.bytesWithOffsets()
+	return b
+}
+
+// bytesWithOffsets returns the bytes of the tokens, together with the offset of each token in the bytes.
+func (toks fullTokens) bytesWithOffsets() ([]byte, []int) {
 	var buf bytes.Buffer
+	offsets := make([]int, len(toks))
 	for i, t := range toks {
 		var s string
 		switch {
 		case t.Type == hclsyntax.TokenType(TokenWildcard):
-			s = wildName(string(t.Bytes), false)
+			s = wildIdent(string(t.Bytes), false, t.Index)
 		case t.Type == hclsyntax.TokenType(TokenWildcardAny):
-			s = wildName(string(t.Bytes), true)
+			s = wildIdent(string(t.Bytes), true, t.Index)
 		case t.Type == hclsyntax.TokenType(TokenAttrWildcard):
-			s = wildAttr(string(t.Bytes), false)
+			s = wildAttr(string(t.Bytes), false, t.Index)
 		case t.Type == hclsyntax.TokenType(TokenAttrWildcardAny):
-			s = wildAttr(string(t.Bytes), true)
+			s = wildAttr(string(t.Bytes), true, t.Index)
+		case t.Type == hclsyntax.TokenType(TokenOpWildcard):
+			// separate the placeholder operator from the operands, e.g. "- -1"
+			buf.WriteByte(' ')
+			s = string(t.Bytes) + " "
 		default:
 			s = string(t.Bytes)
 		}
+		offsets[i] = buf.Len()
 		buf.WriteString(s)
 
 		if i+1 < len(toks) {
@@ -148,5 +934,5 @@ func (toks fullTokens) Bytes() []byte {
 			}
 		}
 	}
-	return buf.Bytes()
+	return buf.Bytes(), offsets
 }
@@ -6,13 +6,41 @@ import (
 )
 
 var usage = func() {
-	fmt.Fprintf(os.Stderr, `usage: hclgrep [options] commands [FILE...]
+	fmt.Fprintf(os.Stderr, `usage: hclgrep [options] commands [FILE|DIR...]
 
-hclgrep performs a query on the given HCL(v2) files.
+hclgrep performs a query on the given HCL(v2) files. The directories are walked recursively for the HCL files.
 
 An option is one of the following:
 
+    -unordered          match the attributes/blocks of a body and the elements of an object regardless of their order, i.e. each
+                        element of the pattern matches a distinct element of the target, while the other elements of the target are
+                        only allowed by an any wildcard (e.g. "@*_")
     -H                  prefix the filename and byte offset of a match (defaults to "true" when reading from multiple files)
+    -vimgrep            print one line per match (or per "-w" value) as "file:line:column: text", where the column is the byte offset
+                        in the line and the text is the first line of the match, which can be parsed by editors (e.g. Vim quickfix)
+    -n                  shorthand of "-vimgrep"
+    -A  number          print the number of context lines after each match, with the line numbers of the matched lines followed by ":"
+                        and the others followed by "-" (windows not adjacent are separated by "--")
+    -B  number          print the number of context lines before each match (see "-A")
+    -C  number          print the number of context lines before and after each match, unless overridden by "-A" or "-B" (see "-A")
+    -color mode         when to colorize the matches, one of "auto" (default, only for a terminal), "always" and "never";
+                        the matched nodes are highlighted, and so is each recorded wildcard value with a distinct color
+    -q                  quiet mode, print nothing and stop at the first match (the exit status tells whether any match is found)
+    -i                  write the substituted content back to the files, instead of printing it (requires "-s")
+    -backup             keep a backup of the original file with the ".orig" suffix when writing in place (requires "-i")
+    -d                  print the unified diff of the substituted content, instead of the content; exit with status 3 if any diff is found (requires "-s")
+    -include glob       select the files matching the glob when walking directories, can be repeated (defaults to "*.tf", "*.tfvars", "*.hcl", "*.pkr.hcl" and "*.nomad")
+    -exclude glob       skip the files and directories matching the glob when walking directories, can be repeated
+    -no-ignore          walk into the VCS (e.g. ".git"), "vendor" and ".terraform" directories, which are skipped by default
+    -j  number          process the given number of files concurrently, the output is in the same order as processing sequentially (defaults to the number of CPUs)
+    -format format      output format of the matches, one of "text" (default), "json" and "sarif":
+                        - json: one JSON object per match (JSON Lines), including the file name, the range, the text and the type of the match, and the recorded wildcards
+                        - sarif: a SARIF 2.1.0 log of all the matches, which can be uploaded to code scanning tools
+    -json               shorthand of "-format json"
+    -c                  print the number of matches of each file as "file:count", instead of the matches
+    -l                  print the names of the files that have any match, instead of the matches
+    -L                  print the names of the files that have no match, instead of the matches
+    -f  file            load the rules from a rule file, can be repeated (see below)
 
 A command is one of the following:
 
@@ -22,6 +50,15 @@ A command is one of the following:
 	-%s  number          navigate up a number of node parents
 	-%s name="regexp"   filter nodes by regexp against wildcard value of "name"
 	-%s  name            print the wildcard node only (must be the last command)
+	-%s  pattern         substitute each matched node with a pattern, print the rewritten file (must be the last command)
+	-%s ( commands ) ( commands ) ...
+	                    run each group of commands against the nodes, keep the nodes found by any group (in source order)
+	-%s ( commands ) ( commands ) ...
+	                    run each group of commands against the nodes, keep the nodes found by every group
+	-%s name            save the nodes as a named set, and start over from the whole file
+	-%s name         add the nodes of the named set
+	-%s name     discard nodes not in the named set
+	-%s name      discard nodes in the named set
 
 A pattern is a piece of HCL code which may include wildcards. It can be:
 
@@ -46,5 +83,149 @@ If "*" is before the name, it will match any number of nodes. Example:
     resource foo "name" {
         @*_  # any number of attributes/blocks inside the resource block body
     }
-`, CmdNameMatch, CmdNameFilterMatch, CmdNameFilterUnMatch, CmdNameParent, CmdNameRx, CmdNameWrite)
+
+Besides "*", the number of nodes can be quantified by "+" (one or more), "?" (zero or one), "{n}" (exactly n), "{n,}"
+(at least n) and "{n,m}" (from n to m), which are all any wildcards. In the unordered mode, each any wildcard takes its
+minimum number of the remaining elements, then the first ones take the rest as many as they allow. Example:
+
+    [$_, $+_]               # a tuple with at least two elements
+
+The any wildcard records the matched nodes as a list, whose source spans from the first node to the last one (the
+block labels are joined by spaces), and whose literal for "-%s" is the literals of the nodes joined by ",". The other
+occurrences of the name must match an equal list. The shorter lists are tried first. Example:
+
+    f($*args) + g($*args) # the same arguments of both calls
+
+The wildcards can be used at any step of a traversal, including the index keys. The any wildcard at a name step
+matches any number of steps. Example:
+
+    var.$*_                  # any reference to a variable
+    aws_instance.$x[$i].id   # matches both "aws_instance.web[0].id" and "aws_instance.db[count.index].id"
+
+A wildcard can be constrained to a kind of node, as ":" followed by the kind right after the name (e.g. "$x:string"),
+where each of the matched nodes must be of the kind for the any wildcard. The kinds of the expression wildcard are:
+
+    string, number, bool, null  a literal value of the type (a string also includes a template without any
+                                interpolation, and the string in a place that a string is accepted, e.g. a block label)
+    template                    a template (i.e. a quoted string or a heredoc)
+    traversal                   a traversal (e.g. "var.foo[0]")
+    call, tuple, object, for,   a function call, a tuple, an object, a for expression, a conditional, an index, a splat
+    conditional, index, splat,  and an operation (e.g. "a + b", "!a") respectively
+    operation
+
+The kinds of the attribute wildcard are "attribute" (an attribute or an object element) and "block". Note that a
+space is needed between the wildcard and the ":" of a conditional whose false result is named as a kind, e.g.
+"$a ? $b : null". Example:
+
+    tags = $x:call # tags computed by a function call, e.g. merge()
+
+A wildcard can be constrained by a regexp, as "~" followed by a quoted string right after the name. The wildcard only
+matches the value whose whole literal (the same as "-%s") matches the regexp (each of the matched nodes for the any
+wildcard). For an attribute wildcard, the literal is the attribute name (block type). Example:
+
+    resource $type~"azurerm_.*" $_ { @*_ } # any azurerm resource
+
+    destination_port_range = $port~"22|\\*"
+
+An expression wildcard in the operator position, i.e. followed by a space and an operand (e.g. "$a $op $b", "$op $a"),
+is an operator wildcard, which matches the operator of a binary or unary operation. Its literal (and the output of
+"-%s") is the operator symbol (e.g. "=="), and it can be constrained to a class of operators by the kinds:
+
+    cmp                         a comparison operator ("==", "!=", "<", "<=", ">", ">=")
+    arith                       an arithmetic operator ("+", "-", "*", "/", "%%", and the unary "-")
+    logic                       a logical operator ("&&", "||", and the unary "!")
+
+The pattern is parsed with the operator wildcard taking the precedence of "==" (or the unary "!"), or of "+" ("-") and
+"||" ("!") for the "arith" and "logic" kinds, which matters when it is combined with other operators without
+parentheses. Example:
+
+    var.$_ $op:cmp $v:number    # any comparison between a variable and a number
+
+A wildcard inside the literal text of a quoted string or a heredoc (including a block label) is a text wildcard, which
+matches a substring of the literal text of the target template (or block label). It is "$" followed by an optional
+quantifier and a name of letters, digits and underscores, e.g. "prod-$x", "${var.env}-$suffix". The text wildcard "$x"
+matches one or more characters, while "$*x", "$+x" and "$?x" match zero or more, one or more, and zero or one
+characters respectively. The shorter substrings are tried first. The substring is recorded as a string, which can be
+matched by the other occurrences of the name, including an expression wildcard matching an equal string literal. A "$"
+not followed by a name is literal, and "$$" is an escaped "$". The text wildcards can also be referenced inside the
+literal text of the substitution pattern of "-%s". Example:
+
+    -x 'name = $_' -v 'name = "${var.env}-$*_"'   # the names not starting with the environment prefix
+
+An alternation "(<alt> | <alt> ...)" matches if any of the alternatives matches, which are either all expressions
+(or strings, e.g. a block type) or all attributes/blocks. The wildcards inside the first matched alternative are
+recorded. The parentheses right after a function name are the ones of the call (e.g. "f(a | b)" matches "f(a)"), so
+an alternation of one argument among others needs its own parentheses (e.g. "f((a | b), $x)"). Example:
+
+    (resource | data) $_ $_ {
+        @*_
+        count = (0 | "0")
+    }
+
+The descendant operator "..." matches somewhere below a point of the pattern:
+
+- Body form: "... <attribute/block>" as an element of a body matches if any descendant of the target body matches the
+  attribute/block. It doesn't consume any element of the body, so the other elements of the body still need to be
+  matched (e.g. by "@*_"). Example:
+
+    resource $_ $_ {
+        @*_
+        ... network_rules { default_action = "Allow" } # anywhere inside the resource
+    }
+
+- Expression form: "...(<expr>)" matches an expression if it, or any of its descendants, matches the expression.
+  Example:
+
+    tags = ...(var.$_) # the tags reference a variable
+
+A negative element "!<attribute/block>" of a body matches if no element of the target body matches the attribute/block,
+or no descendant of the target body with "!... <attribute/block>". It doesn't consume any element of the body. It can
+reference the wildcards recorded by the other elements, but doesn't record any. Example:
+
+    resource azurerm_storage_account $_ {
+        @*_
+        !min_tls_version = $_ # no min_tls_version, regardless of the nested blocks
+    }
+
+The groups of "-%s" and "-%s" are enclosed by "(" and ")" as separate arguments (quoted or escaped in the shell),
+which can be nested, but can't contain "-%s" or "-%s". The wildcard values of the nodes are kept: of the same node found
+by multiple groups, "-%s" keeps the values of the first group, while "-%s" (and "-%s") merges the values of all groups,
+discarding the node if a wildcard has different values. Example:
+
+    -x 'resource $_ $_ {@*_}' -or '(' -g 'count = $_' ')' '(' -g 'for_each = $_' ')'
+
+A set saved by "-%s" can be combined by the following commands, even inside a group. As "-%s" starts over from the
+whole file, the nodes not found inside others can be found by saving the ones inside others. Example:
+
+    -x 'dynamic $_ {@*_}' -x 'count = $_' -as dynamic -x 'count = $_' -subtract dynamic
+
+The substitution pattern of "-%s" is a piece of HCL code which may reference the recorded wildcards by "$name" or
+"@name". The matched nodes are replaced by the pattern with each reference replaced by the source of the wildcard
+value. If the matched nodes overlap, only the first one (in source order) is substituted.
+
+A rule file consists of "rule" blocks, each of which is a named pipeline of commands (except "-%s"). All the rules,
+together with the commands from the command line (if any), are run against each file. The matches of a rule are
+tagged with the rule id. The groups of "or" and "and" are lists of commands, e.g. { or = [[{ g = "a = 1" }], [...]] }.
+Example:
+
+    rule "nsg_allow_ssh" {
+        severity = "error"                      # one of "error", "warning" (default) and "note"
+        message  = "NSG rule allows inbound SSH" # optional
+        commands = [
+            { x = "resource azurerm_network_security_rule $_ {@*_}" },
+            { g = "direction = \"Inbound\"" },
+            { g = "destination_port_range = $port" },
+            { rx = "port=\"22|\\*\"" },
+        ]
+    }
+
+Note that "${" and "%%{" in the commands of a rule file need to be escaped as "$${" and "%%%%{".
+
+The exit status is 0 if any match is found, 1 if no match is found, 2 if an error occurs, and 3 if any diff is found
+with "-d".
+`, CmdNameMatch, CmdNameFilterMatch, CmdNameFilterUnMatch, CmdNameParent, CmdNameRx, CmdNameWrite, CmdNameSubstitute,
+		CmdNameOr, CmdNameAnd, CmdNameAs, CmdNameUnion, CmdNameIntersect, CmdNameSubtract,
+		CmdNameRx, CmdNameRx, CmdNameWrite, CmdNameSubstitute,
+		CmdNameOr, CmdNameAnd, CmdNameWrite, CmdNameSubstitute, CmdNameOr, CmdNameAnd, CmdNameIntersect, CmdNameAs, CmdNameAs,
+		CmdNameSubstitute, CmdNameSubstitute)
 }
@@ -0,0 +1,77 @@
+package hclgrep
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// defaultIncludes are the file name globs selected when walking directories, if no "-include" is specified.
+var defaultIncludes = []string{"*.tf", "*.tfvars", "*.hcl", "*.pkr.hcl", "*.nomad"}
+
+// ignoredDirs are the directories skipped when walking directories, unless "-no-ignore" is specified.
+var ignoredDirs = map[string]bool{
+	".terraform": true,
+	".git":       true,
+	".hg":        true,
+	".svn":       true,
+	".bzr":       true,
+	"vendor":     true,
+}
+
+// expandFiles expands the directories in paths to the files inside them (recursively). The other paths are kept as
+// is, regardless of the include/exclude globs.
+func (m *Matcher) expandFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			// Let the error (if any) be reported when opening the file.
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := d.Name()
+			if d.IsDir() {
+				if p != path && (m.isExcluded(name) || (!m.noIgnore && ignoredDirs[name])) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || m.isExcluded(name) || !m.isIncluded(name) {
+				return nil
+			}
+			files = append(files, p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func (m *Matcher) isIncluded(name string) bool {
+	includes := m.includes
+	if len(includes) == 0 {
+		includes = defaultIncludes
+	}
+	return matchAnyGlob(includes, name)
+}
+
+func (m *Matcher) isExcluded(name string) bool {
+	return matchAnyGlob(m.excludes, name)
+}
+
+func matchAnyGlob(globs []string, name string) bool {
+	for _, glob := range globs {
+		// The globs are validated when parsing the arguments.
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
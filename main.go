@@ -15,11 +15,26 @@ func main() {
 			os.Exit(0)
 		}
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(2)
 	}
 	m := hclgrep.NewMatcher(opts...)
-	if err := m.Files(files); err != nil {
+	n, err := m.Files(files)
+	if err != nil && !errors.Is(err, hclgrep.ErrDiff) {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
 	}
+	os.Exit(exitStatus(n, err))
+}
+
+// exitStatus returns the exit status of the matches: 0 if any match is found, 1 if no match is found, 2 if any error
+// occurs, 3 if any diff is found in diff mode.
+func exitStatus(n int, err error) int {
+	switch {
+	case errors.Is(err, hclgrep.ErrDiff):
+		return 3
+	case err != nil:
+		return 2
+	case n == 0:
+		return 1
+	}
+	return 0
 }
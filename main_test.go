@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/magodo/hclgrep/hclgrep"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		n    int
+		err  error
+		want int
+	}{
+		{1, nil, 0},
+		// no match
+		{0, nil, 1},
+		{0, errors.New("cannot parse source"), 2},
+		{1, hclgrep.ErrDiff, 3},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			if got := exitStatus(tc.n, tc.err); got != tc.want {
+				t.Fatalf("wanted %d, got %d", tc.want, got)
+			}
+		})
+	}
+}